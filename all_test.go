@@ -1478,3 +1478,239 @@ func TestPR4(t *testing.T) {
 		t.Fatalf("key lost: %v", k)
 	}
 }
+
+func TestTreeFromSorted(t *testing.T) {
+	for _, n := range []int{0, 1, 2*kd - 1, 2 * kd, 2*kd + 1, 1000, 2*kd*(2*kx+1) + 1, 1e5} {
+		src := TreeNew(cmp)
+		for i := 0; i < n; i++ {
+			src.Set(2*i, -2*i)
+		}
+		next := func() (interface{}, interface{}, error) { return nil, nil, io.EOF }
+		if n != 0 {
+			en, err := src.SeekFirst()
+			if err != nil {
+				t.Fatal(n, err)
+			}
+
+			next = en.Next
+		}
+		tr, err := TreeFromSorted(cmp, next)
+		if err != nil {
+			t.Fatal(n, err)
+		}
+
+		if g, e := tr.Len(), n; g != e {
+			t.Fatal(n, g, e)
+		}
+
+		en, err := tr.SeekFirst()
+		for i := 0; i < n; i++ {
+			if err != nil {
+				t.Fatal(n, i, err)
+			}
+
+			k, v, err := en.Next()
+			if err != nil {
+				t.Fatal(n, i, err)
+			}
+
+			if k != 2*i || v != -2*i {
+				t.Fatal(n, i, k, v)
+			}
+		}
+		if n != 0 {
+			if _, _, err := en.Next(); err != io.EOF {
+				t.Fatal(n, err)
+			}
+		}
+
+		if en, err = tr.SeekLast(); n != 0 {
+			if err != nil {
+				t.Fatal(n, err)
+			}
+
+			for i := n - 1; i >= 0; i-- {
+				if k, _, err := en.Prev(); err != nil || k != 2*i {
+					t.Fatal(n, i, k, err)
+				}
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			tr.Set(2*i+1, 0)
+			if i%3 == 0 {
+				tr.Delete(2 * i)
+			}
+		}
+		for i := 0; i < n; i++ {
+			if _, ok := tr.Get(2*i + 1); !ok {
+				t.Fatal(n, i)
+			}
+		}
+	}
+
+	for _, a := range [][]int{{1, 1}, {2, 1}, {1, 2, 3, 3}, {1, 3, 2}} {
+		i := 0
+		_, err := TreeFromSorted(cmp, func() (interface{}, interface{}, error) {
+			if i == len(a) {
+				return nil, nil, io.EOF
+			}
+
+			i++
+			return a[i-1], 0, nil
+		})
+		if err == nil {
+			t.Fatal(a)
+		}
+	}
+
+	_, err := TreeFromSorted(cmp, func() (interface{}, interface{}, error) { return nil, nil, io.ErrUnexpectedEOF })
+	if g, e := err, io.ErrUnexpectedEOF; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestTreeFromSortedFill(t *testing.T) {
+	const n = 1e5
+	for _, fill := range []float64{0.5, 0.75, 0.9, 1} {
+		j := 0
+		tr, err := TreeFromSortedWithOptions(cmp, func() (interface{}, interface{}, error) {
+			if j == n {
+				return nil, nil, io.EOF
+			}
+
+			j++
+			return 2 * (j - 1), 0, nil
+		}, LoadOptions{Fill: fill})
+		if err != nil {
+			t.Fatal(fill, err)
+		}
+
+		if err := tr.Verify(); err != nil {
+			t.Fatal(fill, err)
+		}
+
+		dmax := int(math.Round(fill * 2 * kd))
+		var keys []int
+		for q := tr.first; q.n != nil && q.n.n != nil; q = q.n {
+			if q.c != dmax {
+				t.Fatal(fill, q.c, dmax)
+			}
+
+			keys = append(keys, q.d[0].k.(int))
+		}
+		pages := func() (n int) {
+			for q := tr.first; q != nil; q = q.n {
+				n++
+			}
+			return n
+		}
+		np := pages()
+
+		// Insert a key into every data page but the last two.
+		for _, k := range keys {
+			tr.Set(k+1, 0)
+		}
+		switch g := pages(); {
+		case fill < 1 && g != np:
+			t.Fatal(fill, g, np)
+		case fill == 1 && g == np:
+			t.Fatal(fill, g, np)
+		}
+
+		if err := tr.Verify(); err != nil {
+			t.Fatal(fill, err)
+		}
+	}
+
+	for _, fill := range []float64{-1, 0.4, 1.1, math.NaN()} {
+		if _, err := TreeFromSortedWithOptions(cmp, func() (interface{}, interface{}, error) { return nil, nil, io.EOF }, LoadOptions{Fill: fill}); err == nil {
+			t.Fatal(fill)
+		}
+	}
+}
+
+func BenchmarkTreeFromSorted1e3(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e3)
+}
+
+func BenchmarkTreeFromSorted1e4(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e4)
+}
+
+func BenchmarkTreeFromSorted1e5(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e5)
+}
+
+func BenchmarkTreeFromSorted1e6(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e6)
+}
+
+func benchmarkTreeFromSorted(b *testing.B, n int) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		debug.FreeOSMemory()
+		j := 0
+		next := func() (interface{}, interface{}, error) {
+			if j == n {
+				return nil, nil, io.EOF
+			}
+
+			j++
+			return j, j, nil
+		}
+		b.StartTimer()
+		r, err := TreeFromSorted(cmp, next)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		r.Close()
+	}
+	b.StopTimer()
+}
+
+func TestVerify(t *testing.T) {
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		tr := TreeNew(cmp)
+		for i := 0; i < n; i++ {
+			tr.Set(10*i, i)
+		}
+		if err := tr.Verify(); err != nil {
+			t.Fatal(n, err)
+		}
+
+		for i := 0; i < n; i += 3 {
+			tr.Delete(10 * i)
+		}
+		if err := tr.Verify(); err != nil {
+			t.Fatal(n, err)
+		}
+
+		tr.Close()
+	}
+
+	corrupt := func(f func(tr *Tree), e string) {
+		tr := TreeNew(cmp)
+		for i := 0; i < 1e4; i++ {
+			tr.Set(10*i, i)
+		}
+		f(tr)
+		err := tr.Verify()
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Fatalf("%v, expected %q", err, e)
+		}
+	}
+	corrupt(func(tr *Tree) { tr.c++ }, "Len is 10001")
+	corrupt(func(tr *Tree) {
+		q := tr.first.n
+		q.d[0].k, q.d[1].k = q.d[1].k, q.d[0].k
+	}, "key #1")
+	corrupt(func(tr *Tree) { tr.first.n.d[0].k = 5 }, "not greater than the previous key")
+	corrupt(func(tr *Tree) { tr.r.(*x).x[0].ch.(*x).x[1].k = int(1e6) }, "page root/0: key #1 1000000")
+	corrupt(func(tr *Tree) { tr.last.p = tr.first }, "not linked to the previous data page")
+	corrupt(func(tr *Tree) { tr.last = tr.last.p }, "last is not the last data page")
+	corrupt(func(tr *Tree) { tr.Set(1, 1); tr.first.c = 1 }, "minimum is")
+}
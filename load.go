@@ -0,0 +1,253 @@
+// Copyright 2014 The b Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b"
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// TreeFromSorted returns a newly created Tree having all the KV pairs produced
+// by next. The compare function is used for key collation. Next is called
+// until it returns a non nil error, io.EOF signals the end of the input. The
+// Next method of an Enumerator can be used as the next function.
+//
+// The keys must be produced in strictly increasing order, otherwise
+// TreeFromSorted returns an error. Any error other than io.EOF returned by
+// next is returned as well.
+//
+// The tree is built bottom-up in a single pass, without searching for the
+// insertion points and without splitting pages, which makes it much faster
+// than calling Set for every item. The pages are filled completely, see
+// TreeFromSortedWithOptions for loading a tree that will be modified later.
+func TreeFromSorted(cmp Cmp, next func() (k interface{} /*K*/, v interface{} /*V*/, err error)) (*Tree, error) {
+	return TreeFromSortedWithOptions(cmp, next, LoadOptions{})
+}
+
+// LoadOptions amend the behavior of TreeFromSortedWithOptions.
+type LoadOptions struct {
+	// Fill is the fraction of the capacity of the pages filled by the
+	// loader. It must be in [0.5, 1], zero selects 1. The first insert
+	// into a completely filled page splits it, so a tree that will be
+	// modified after loading is better loaded with a smaller fill factor.
+	Fill float64
+}
+
+// TreeFromSortedWithOptions is like TreeFromSorted, but the fill factor of the
+// pages is selected by o. The last two pages of every level may be filled
+// differently, they are balanced against each other when the input ends.
+func TreeFromSortedWithOptions(cmp Cmp, next func() (k interface{} /*K*/, v interface{} /*V*/, err error), o LoadOptions) (*Tree, error) {
+	fill := o.Fill
+	if fill == 0 {
+		fill = 1
+	}
+	if !(fill >= 0.5 && fill <= 1) {
+		return nil, fmt.Errorf("TreeFromSorted: fill factor %v not in [0.5, 1]", o.Fill)
+	}
+
+	t := TreeNew(cmp)
+	l := newLoader(t, fill)
+	var last interface{} /*K*/
+	for {
+		k, v, err := next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			t.r = l.finish()
+			t.Close()
+			return nil, err
+		}
+
+		if t.c != 0 && cmp(last, k) >= 0 {
+			t.r = l.finish()
+			t.Close()
+			return nil, fmt.Errorf("TreeFromSorted: item #%d: key out of order", t.c)
+		}
+
+		last = k
+		l.add(k, v)
+	}
+
+	t.r = l.finish()
+	q := t.r
+	for {
+		x, ok := q.(*x)
+		if !ok {
+			break
+		}
+
+		q = x.x[x.c].ch
+	}
+	if q != nil {
+		t.last = q.(*d)
+	}
+	return t, nil
+}
+
+// loader builds a tree bottom-up from sorted items. The pages are added at
+// the right edge of every level. The last two pages of a level are kept
+// pending so that the last page can be balanced against its left sibling
+// when the input ends.
+type loader struct {
+	dmax   int // items in a filled data page
+	levels []level
+	t      *Tree
+	xmax   int // keys in a filled index page
+}
+
+// newLoader returns a loader filling the pages of t to the fraction fill of
+// their capacity, but not below their minimum fill.
+func newLoader(t *Tree, fill float64) *loader {
+	l := &loader{
+		dmax: int(math.Round(fill * 2 * kd)),
+		t:    t,
+		xmax: int(math.Round(fill * 2 * kx)),
+	}
+	if l.dmax < kd {
+		l.dmax = kd
+	}
+	if l.xmax < kx {
+		l.xmax = kx
+	}
+	return l
+}
+
+type level struct {
+	cur   interface{} // The page being filled.
+	curK  interface{} /*K*/ // The first key in the subtree of cur.
+	prev  interface{} // The filled page preceding cur.
+	prevK interface{} /*K*/ // The first key in the subtree of prev.
+}
+
+func (l *loader) add(k interface{} /*K*/, v interface{} /*V*/) {
+	t := l.t
+	if len(l.levels) == 0 {
+		l.levels = append(l.levels, level{})
+	}
+	q, _ := l.levels[0].cur.(*d)
+	if q == nil || q.c == l.dmax {
+		z := btDPool.Get().(*d)
+		z.setTree(t)
+		if z.p = t.last; z.p != nil {
+			z.p.n = z
+		} else {
+			t.first = z
+		}
+		t.last = z
+		l.shift(0, z, k)
+		q = z
+	}
+	q.d[q.c].k, q.d[q.c].v = k, v
+	q.c++
+	t.c++
+}
+
+// shift makes page q, having the first key k in its subtree, the page being
+// filled at level i.
+func (l *loader) shift(i int, q interface{}, k interface{} /*K*/) {
+	lv := &l.levels[i]
+	if lv.prev != nil {
+		l.emit(i+1, lv.prevK, lv.prev)
+		lv = &l.levels[i]
+	}
+	lv.prev, lv.prevK = lv.cur, lv.curK
+	lv.cur, lv.curK = q, k
+}
+
+// emit adds page ch, having the first key sep in its subtree, to level i.
+func (l *loader) emit(i int, sep interface{} /*K*/, ch interface{}) {
+	if i == len(l.levels) {
+		l.levels = append(l.levels, level{})
+	}
+	q, _ := l.levels[i].cur.(*x)
+	if q == nil || q.c == l.xmax {
+		l.shift(i, newX(ch), sep)
+		return
+	}
+
+	q.x[q.c].k = sep
+	q.c++
+	q.x[q.c].ch = ch
+}
+
+// finish completes all the levels and returns the root page, if any.
+func (l *loader) finish() interface{} {
+	for i := 0; i < len(l.levels); i++ {
+		lv := l.levels[i]
+		if lv.prev == nil {
+			if q, ok := lv.cur.(*x); ok && q.c == 0 {
+				// The two pages of the level below were merged.
+				r := q.x[0].ch
+				*q = zx
+				btXPool.Put(q)
+				return r
+			}
+
+			return lv.cur
+		}
+
+		sep, ok := l.balance(lv.prev, lv.curK, lv.cur)
+		l.emit(i+1, lv.prevK, lv.prev)
+		if ok {
+			l.emit(i+1, sep, lv.cur)
+		}
+	}
+	return nil
+}
+
+// balance fixes the fill of the last page r of a level against its filled left
+// sibling p. sep is the first key in the subtree of r. If r was merged into p,
+// balance returns false. Otherwise it returns the, possibly new, first key in
+// the subtree of r.
+func (l *loader) balance(p interface{}, sep interface{} /*K*/, r interface{}) (interface{} /*K*/, bool) {
+	switch q := p.(type) {
+	case *x:
+		r := r.(*x)
+		switch {
+		case r.c >= kx-1:
+			// ok
+		case q.c+r.c+1 <= 2*kx+1:
+			q.x[q.c].k = sep
+			copy(q.x[q.c+1:], r.x[:r.c+1])
+			q.c += r.c + 1
+			*r = zx
+			btXPool.Put(r)
+			return nil, false
+		default:
+			// Move the last m children of x to r.
+			m := (q.c - r.c) / 2
+			copy(r.x[m:], r.x[:r.c+1])
+			copy(r.x[:m], q.x[q.c-m+1:q.c+1])
+			r.x[m-1].k = sep
+			r.c += m
+			q.c -= m
+			sep = q.x[q.c].k
+			q.x[q.c].k = zk
+			for i := q.c + 1; i <= q.c+m; i++ {
+				q.x[i] = zxe
+			}
+		}
+		return sep, true
+	case *d:
+		r := r.(*d)
+		switch {
+		case r.c >= kd:
+			// ok
+		case q.c+r.c <= 2*kd:
+			q.mvL(r, r.c)
+			q.n = nil
+			*r = zd
+			btDPool.Put(r)
+			return nil, false
+		default:
+			q.mvR(r, kd-r.c)
+		}
+		return r.d[0].k, true
+	}
+	panic("internal error")
+}
@@ -6,100 +6,72 @@ package b // import "modernc.org/b/v2"
 
 import (
 	"bytes"
+	stdcmp "cmp"
+	"encoding"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 
 	"modernc.org/mathutil"
-	"modernc.org/strutil"
 )
 
 // ============================================================================
 
-func (t *Tree[K, V]) isNil(p interface{}) bool {
-	switch x := p.(type) {
-	case *x[K, V]:
-		if x == nil {
-			return true
-		}
-	case *d[K, V]:
-		if x == nil {
-			return true
-		}
-	}
-	return false
-}
-
 func (t *Tree[K, V]) dump() string {
 	var buf bytes.Buffer
-	f := strutil.IndentFormatter(&buf, "\t")
+	t.Dump(&buf, nil)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
 
-	num := map[interface{}]int{}
-	visited := map[interface{}]bool{}
+// counts returns the number of items in the subtree of p and verifies the item
+// counts of all the index pages in it.
+func (t *Tree[K, V]) counts(p interface{}) (int, error) {
+	switch x := p.(type) {
+	case *x[K, V]:
+		n := 0
+		for i := 0; i <= x.c; i++ {
+			c, err := t.counts(x.x[i].ch)
+			if err != nil {
+				return 0, err
+			}
 
-	handle := func(p interface{}) int {
-		if t.isNil(p) {
-			return 0
+			n += c
 		}
-
-		if n, ok := num[p]; ok {
-			return n
+		if n != x.n {
+			return 0, fmt.Errorf("X(%p): n %d, items %d", x, x.n, n)
 		}
 
-		n := len(num) + 1
-		num[p] = n
-		return n
+		return n, nil
+	case *d[K, V]:
+		return x.c, nil
 	}
+	return 0, nil
+}
 
-	var pagedump func(interface{}, string)
-	pagedump = func(p interface{}, pref string) {
-		if t.isNil(p) || visited[p] {
-			return
-		}
-
-		visited[p] = true
-		switch x := p.(type) {
-		case *x[K, V]:
-			h := handle(p)
-			n := 0
-			for i, v := range x.x {
-				if v.ch != nil {
-					n = i + 1
-				}
-			}
-			f.Format("%sX#%d(%p) n %d:%d {", pref, h, x, x.c, n)
-			a := []interface{}{}
-			for i, v := range x.x[:n] {
-				a = append(a, v.ch)
-				if i != 0 {
-					f.Format(" ")
-				}
-				f.Format("(C#%d K %v)", handle(v.ch), v.k)
-			}
-			f.Format("}\n")
-			for _, p := range a {
-				pagedump(p, pref+". ")
-			}
-		case *d[K, V]:
-			h := handle(p)
-			f.Format("%sD#%d(%p) P#%d N#%d n %d {", pref, h, x, handle(x.p), handle(x.n), x.c)
-			for i, v := range x.d {
-				if i != 0 {
-					f.Format(" ")
-				}
-				f.Format("%v:%v", v.k, v.v)
-			}
-			f.Format("}\n")
-		}
+func (t *Tree[K, V]) checkCounts() error {
+	n, err := t.counts(t.r)
+	if err != nil {
+		return err
 	}
 
-	pagedump(t.r, "")
-	s := buf.String()
-	if s != "" {
-		s = s[:len(s)-1]
+	if n != t.c {
+		return fmt.Errorf("tree items %d, Len %d", n, t.c)
 	}
-	return s
+
+	return nil
 }
 
 func rng() *mathutil.FC32 {
@@ -336,10 +308,14 @@ func BenchmarkSetSeq1e6(b *testing.B) {
 }
 
 func benchmarkSetSeq(b *testing.B, n int) {
+	benchmarkSetSeqOf(b, n, func() *Tree[int, int] { return TreeNew[int, int](cmp) })
+}
+
+func benchmarkSetSeqOf(b *testing.B, n int, newTree func() *Tree[int, int]) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
-		r := TreeNew[int, int](cmp)
+		r := newTree()
 		debug.FreeOSMemory()
 		b.StartTimer()
 		for j := 0; j < n; j++ {
@@ -368,7 +344,10 @@ func BenchmarkGetSeq1e6(b *testing.B) {
 }
 
 func benchmarkGetSeq(b *testing.B, n int) {
-	r := TreeNew[int, int](cmp)
+	benchmarkGetSeqOf(b, n, TreeNew[int, int](cmp))
+}
+
+func benchmarkGetSeqOf(b *testing.B, n int, r *Tree[int, int]) {
 	for i := 0; i < n; i++ {
 		r.Set(i, i)
 	}
@@ -383,6 +362,73 @@ func benchmarkGetSeq(b *testing.B, n int) {
 	r.Close()
 }
 
+// The Benchmark{Ordered,StdCompare}{Set,Get}Seq pairs compare a tree created
+// by NewOrdered with the equivalent TreeNew(cmp.Compare[int]).
+
+func BenchmarkOrderedSetSeq1e3(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e3, func() *Tree[int, int] { return NewOrdered[int, int]() })
+}
+
+func BenchmarkOrderedSetSeq1e4(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e4, func() *Tree[int, int] { return NewOrdered[int, int]() })
+}
+
+func BenchmarkOrderedSetSeq1e5(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e5, func() *Tree[int, int] { return NewOrdered[int, int]() })
+}
+
+func BenchmarkOrderedSetSeq1e6(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e6, func() *Tree[int, int] { return NewOrdered[int, int]() })
+}
+
+func BenchmarkStdCompareSetSeq1e3(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e3, func() *Tree[int, int] { return TreeNew[int, int](stdcmp.Compare[int]) })
+}
+
+func BenchmarkStdCompareSetSeq1e4(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e4, func() *Tree[int, int] { return TreeNew[int, int](stdcmp.Compare[int]) })
+}
+
+func BenchmarkStdCompareSetSeq1e5(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e5, func() *Tree[int, int] { return TreeNew[int, int](stdcmp.Compare[int]) })
+}
+
+func BenchmarkStdCompareSetSeq1e6(b *testing.B) {
+	benchmarkSetSeqOf(b, 1e6, func() *Tree[int, int] { return TreeNew[int, int](stdcmp.Compare[int]) })
+}
+
+func BenchmarkOrderedGetSeq1e3(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e3, NewOrdered[int, int]())
+}
+
+func BenchmarkOrderedGetSeq1e4(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e4, NewOrdered[int, int]())
+}
+
+func BenchmarkOrderedGetSeq1e5(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e5, NewOrdered[int, int]())
+}
+
+func BenchmarkOrderedGetSeq1e6(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e6, NewOrdered[int, int]())
+}
+
+func BenchmarkStdCompareGetSeq1e3(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e3, TreeNew[int, int](stdcmp.Compare[int]))
+}
+
+func BenchmarkStdCompareGetSeq1e4(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e4, TreeNew[int, int](stdcmp.Compare[int]))
+}
+
+func BenchmarkStdCompareGetSeq1e5(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e5, TreeNew[int, int](stdcmp.Compare[int]))
+}
+
+func BenchmarkStdCompareGetSeq1e6(b *testing.B) {
+	benchmarkGetSeqOf(b, 1e6, TreeNew[int, int](stdcmp.Compare[int]))
+}
+
 func BenchmarkSetRnd1e3(b *testing.B) {
 	benchmarkSetRnd(b, 1e3)
 }
@@ -1429,3 +1475,3033 @@ func TestPR4(t *testing.T) {
 		t.Fatalf("key lost: %v", k)
 	}
 }
+
+func TestAll(t *testing.T) {
+	const N = 1 << 12
+	tr := TreeNew[int, int](cmp)
+	for range tr.All() {
+		t.Fatal("unexpected item")
+	}
+
+	for i := 0; i < N; i++ {
+		tr.Set(2*i, 2*i+1)
+	}
+	j := 0
+	for k, v := range tr.All() {
+		if g, e := k, 2*j; g != e {
+			t.Fatal(j, g, e)
+		}
+
+		if g, e := v, 2*j+1; g != e {
+			t.Fatal(j, g, e)
+		}
+
+		j++
+	}
+	if g, e := j, N; g != e {
+		t.Fatal(g, e)
+	}
+
+	j = 0
+	for k := range tr.All() {
+		if j == 10 {
+			break
+		}
+
+		if g, e := k, 2*j; g != e {
+			t.Fatal(j, g, e)
+		}
+
+		j++
+	}
+	if g, e := j, 10; g != e {
+		t.Fatal(g, e)
+	}
+
+	// Mutate the tree while iterating: the iteration must resume after the
+	// last returned key.
+	j = 0
+	for k := range tr.All() {
+		if g, e := k, 4*j; g != e {
+			t.Fatal(j, g, e)
+		}
+
+		tr.Delete(k + 2)
+		tr.Set(k-1, 0)
+		j++
+	}
+	if g, e := j, N/2; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestEnumeratorResync(t *testing.T) {
+	tr := TreeNew[int, int](cmp)
+	for i := 0; i < 10; i++ {
+		tr.Set(10*i, 0)
+	}
+	en, _ := tr.Seek(30)
+	if k, _, _ := en.Next(); k != 30 {
+		t.Fatal(k)
+	}
+
+	// The enumeration resumes at the last returned key if it still exists.
+	tr.Set(35, 0)
+	if k, _, _ := en.Next(); k != 30 {
+		t.Fatal(k)
+	}
+
+	if k, _, _ := en.Next(); k != 35 {
+		t.Fatal(k)
+	}
+
+	tr.Set(45, 0)
+	if k, _, _ := en.Prev(); k != 35 {
+		t.Fatal(k)
+	}
+
+	// Otherwise at the following key in the direction of the move.
+	tr.Delete(35)
+	if k, _, _ := en.Prev(); k != 30 {
+		t.Fatal(k)
+	}
+
+	tr.Delete(30)
+	if k, _, _ := en.Next(); k != 40 {
+		t.Fatal(k)
+	}
+
+	// Resync at the end of a data page.
+	for i := 10; i < 10*kd; i++ {
+		tr.Set(10*i, 0)
+	}
+	k := tr.first.d[tr.first.c-1].k
+	prev, next := tr.first.d[tr.first.c-2].k, tr.first.n.d[0].k
+	en, _ = tr.Seek(k)
+	if g, _, _ := en.Next(); g != k {
+		t.Fatal(g, k)
+	}
+
+	tr.Delete(k)
+	if g, _, _ := en.Prev(); g != prev {
+		t.Fatal(g, prev)
+	}
+
+	en, _ = tr.Seek(prev)
+	if g, _, _ := en.Next(); g != prev {
+		t.Fatal(g, prev)
+	}
+
+	tr.Delete(prev)
+	if g, _, _ := en.Next(); g != next {
+		t.Fatal(g, next)
+	}
+}
+
+func TestEnumeratorResume(t *testing.T) {
+	// Keys 0, 10, ..., 190 in data pages of at most 4 items. The
+	// enumerator returns 30 and 40 by Next or 60 and 50 by Prev, then the
+	// mutation is made and the next two moves are checked. After a
+	// mutation, the enumerator resumes at the key it returned last, if it
+	// still exists.
+	for _, c := range []struct {
+		dir  int
+		name string
+		mut  func(*Tree[int, int])
+		want []int
+	}{
+		{1, "insert before", func(tr *Tree[int, int]) { tr.Set(35, 0) }, []int{40, 50}},
+		{1, "insert after", func(tr *Tree[int, int]) { tr.Set(45, 0) }, []int{40, 45}},
+		{1, "delete at", func(tr *Tree[int, int]) { tr.Delete(40) }, []int{50, 60}},
+		{1, "delete after", func(tr *Tree[int, int]) { tr.Delete(50) }, []int{40, 60}},
+		{1, "delete before", func(tr *Tree[int, int]) { tr.Delete(30) }, []int{40, 50}},
+		{1, "set at", func(tr *Tree[int, int]) { tr.Set(40, 1) }, []int{50, 60}},
+		{-1, "insert before", func(tr *Tree[int, int]) { tr.Set(55, 0) }, []int{50, 40}},
+		{-1, "insert after", func(tr *Tree[int, int]) { tr.Set(45, 0) }, []int{50, 45}},
+		{-1, "delete at", func(tr *Tree[int, int]) { tr.Delete(50) }, []int{40, 30}},
+		{-1, "delete after", func(tr *Tree[int, int]) { tr.Delete(40) }, []int{50, 30}},
+		{-1, "delete before", func(tr *Tree[int, int]) { tr.Delete(60) }, []int{50, 40}},
+		{-1, "set at", func(tr *Tree[int, int]) { tr.Set(50, 1) }, []int{40, 30}},
+	} {
+		tr := TreeNewWithOptions[int, int](cmp, Options{IndexFanout: 6, LeafFanout: 4})
+		for i := 0; i < 20; i++ {
+			tr.Set(10*i, 0)
+		}
+		move := func(e *Enumerator[int, int]) int {
+			var k int
+			var err error
+			switch c.dir {
+			case 1:
+				k, _, err = e.Next()
+			default:
+				k, _, err = e.Prev()
+			}
+			if err != nil {
+				t.Fatal(c.dir, c.name, err)
+			}
+
+			return k
+		}
+		e, _ := tr.Seek(30)
+		if c.dir < 0 {
+			e, _ = tr.Seek(60)
+		}
+		move(e)
+		move(e)
+		c.mut(tr)
+		for _, w := range c.want {
+			if g := move(e); g != w {
+				t.Fatal(c.dir, c.name, g, w)
+			}
+		}
+		e.Close()
+	}
+}
+
+func TestBackward(t *testing.T) {
+	const N = 1 << 12
+	tr := TreeNew[int, int](cmp)
+	for range tr.Backward() {
+		t.Fatal("unexpected item")
+	}
+
+	for i := 0; i < N; i++ {
+		tr.Set(2*i, 2*i+1)
+	}
+	j := N - 1
+	for k, v := range tr.Backward() {
+		if g, e := k, 2*j; g != e {
+			t.Fatal(j, g, e)
+		}
+
+		if g, e := v, 2*j+1; g != e {
+			t.Fatal(j, g, e)
+		}
+
+		tr.Delete(k)
+		j--
+	}
+	if g, e := j, -1; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := tr.Len(), 0; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestRange(t *testing.T) {
+	const N = 1 << 10
+	tr := TreeNew[int, int](cmp)
+	for range tr.Range(0, N) {
+		t.Fatal("unexpected item")
+	}
+
+	for i := 0; i < N; i++ {
+		tr.Set(2*i, 0)
+	}
+	for lo := -3; lo < 2*N+3; lo += 7 {
+		for hi := lo - 2; hi < 2*N+3; hi += 11 {
+			var a []int
+			for k := range tr.Range(lo, hi) {
+				a = append(a, k)
+			}
+			var e []int
+			for k := lo; k < hi; k++ {
+				if k >= 0 && k < 2*N && k%2 == 0 {
+					e = append(e, k)
+				}
+			}
+			if g, e := len(a), len(e); g != e {
+				t.Fatal(lo, hi, g, e)
+			}
+
+			for i := range a {
+				if g, e := a[i], e[i]; g != e {
+					t.Fatal(lo, hi, i, g, e)
+				}
+			}
+		}
+	}
+}
+
+func TestRankSelect(t *testing.T) {
+	const N = 40000
+	tr := TreeNew[int, int](cmp)
+	if g, e := tr.Rank(42), 0; g != e {
+		t.Fatal(g, e)
+	}
+
+	if k, v := tr.Select(0); k != 0 || v != 0 {
+		t.Fatal(k, v)
+	}
+
+	rng := rng()
+	m := map[int]bool{}
+	check := func() {
+		if err := tr.checkCounts(); err != nil {
+			t.Fatal(err)
+		}
+
+		a := make([]int, 0, len(m))
+		for k := range m {
+			a = append(a, k)
+		}
+		sort.Ints(a)
+		for i, k := range a {
+			if g, e := tr.Rank(k), i; g != e {
+				t.Fatal(k, g, e)
+			}
+
+			if g, e := tr.Rank(k+1), i+1; g != e {
+				t.Fatal(k+1, g, e)
+			}
+
+			g, v := tr.Select(i)
+			if g != k || v != -k {
+				t.Fatal(i, g, v, k)
+			}
+		}
+		if k, v := tr.Select(len(a)); k != 0 || v != 0 {
+			t.Fatal(k, v)
+		}
+	}
+
+	for i := 0; i < N; i++ {
+		k := 2 * (rng.Next() % (N / 2))
+		m[k] = true
+		switch i % 3 {
+		case 0:
+			tr.Set(k, -k)
+		default:
+			tr.Put(k, func(int, bool) (int, bool) { return -k, true })
+		}
+	}
+	check()
+	for i := 0; i < N; i++ {
+		k := 2 * (rng.Next() % (N / 2))
+		delete(m, k)
+		tr.Delete(k)
+		if i%(N/8) == 0 {
+			check()
+		}
+	}
+	check()
+	for k := range m {
+		delete(m, k)
+		tr.Delete(k)
+	}
+	check()
+}
+
+func TestDeleteRange(t *testing.T) {
+	const N = 1 << 16
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 2*kd + 1, 1000, N} {
+		for i := 0; i < 20; i++ {
+			tr := TreeNew[int, int](cmp)
+			for j := 0; j < n; j++ {
+				tr.Set(2*j, 2*j)
+			}
+			lo := rng.Next() % (2*n + 10)
+			hi := lo + rng.Next()%(2*n/(i+1)+10)
+			if i == 0 {
+				lo, hi = -1, 2*n
+			}
+			e := 0
+			for j := 0; j < n; j++ {
+				if 2*j >= lo && 2*j < hi {
+					e++
+				}
+			}
+			if g := tr.DeleteRange(lo, hi); g != e {
+				t.Fatal(n, i, lo, hi, g, e)
+			}
+
+			if g, e := tr.Len(), n-e; g != e {
+				t.Fatal(n, i, g, e)
+			}
+
+			if err := tr.checkCounts(); err != nil {
+				t.Fatal(n, i, err)
+			}
+
+			j := 0
+			for k := range tr.All() {
+				for 2*j >= lo && 2*j < hi {
+					j++
+				}
+				if g, e := k, 2*j; g != e {
+					t.Fatal(n, i, g, e)
+				}
+
+				j++
+			}
+			j = 0
+			for range tr.Backward() {
+				j++
+			}
+			if g, e := j, tr.Len(); g != e {
+				t.Fatal(n, i, g, e)
+			}
+
+			for j := 0; j < n; j++ {
+				tr.Set(2*j+1, 0)
+			}
+			if err := tr.checkCounts(); err != nil {
+				t.Fatal(n, i, err)
+			}
+		}
+	}
+}
+
+func seqN(n int) func(yield func(int, int) bool) {
+	return func(yield func(int, int) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(2*i, -2*i) {
+				return
+			}
+		}
+	}
+}
+
+func TestTreeFromSorted(t *testing.T) {
+	for _, n := range []int{0, 1, 2*kd - 1, 2 * kd, 2*kd + 1, 1000, 2*kd*(2*kx+1) + 1, 1e5} {
+		tr, err := TreeFromSorted[int, int](cmp, seqN(n))
+		if err != nil {
+			t.Fatal(n, err)
+		}
+
+		if g, e := tr.Len(), n; g != e {
+			t.Fatal(n, g, e)
+		}
+
+		if err := tr.checkCounts(); err != nil {
+			t.Fatal(n, err)
+		}
+
+		j := 0
+		for k, v := range tr.All() {
+			if k != 2*j || v != -2*j {
+				t.Fatal(n, j, k, v)
+			}
+
+			j++
+		}
+		if g, e := j, n; g != e {
+			t.Fatal(n, g, e)
+		}
+
+		for k, v := range tr.Backward() {
+			j--
+			if k != 2*j || v != -2*j {
+				t.Fatal(n, j, k, v)
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			tr.Set(2*i+1, 0)
+			if i%3 == 0 {
+				tr.Delete(2 * i)
+			}
+		}
+		if err := tr.checkCounts(); err != nil {
+			t.Fatal(n, err)
+		}
+	}
+
+	long := make([]int, 5000) // The error path returns many pages to the pools.
+	for i := range long {
+		long[i] = i
+	}
+	long = append(long, 42)
+	for _, a := range [][]int{{1, 1}, {2, 1}, {1, 2, 3, 3}, {1, 3, 2}, long} {
+		_, err := TreeFromSorted[int, int](cmp, func(yield func(int, int) bool) {
+			for _, k := range a {
+				if !yield(k, 0) {
+					return
+				}
+			}
+		})
+		if err == nil {
+			t.Fatal(a)
+		}
+	}
+}
+
+func TestTreeFromSortedFill(t *testing.T) {
+	const n = 1e5
+	for _, o := range []LoadOptions{
+		{Fill: 0.5},
+		{Fill: 0.75},
+		{Fill: 0.9},
+		{Fill: 1},
+		{Options: Options{IndexFanout: 6, LeafFanout: 4}, Fill: 0.5},
+		{Options: Options{IndexFanout: 10, LeafFanout: 8}, Fill: 0.75},
+	} {
+		tr, err := TreeFromSortedWithOptions[int, int](cmp, seqN(n), o)
+		if err != nil {
+			t.Fatal(o, err)
+		}
+
+		if err := tr.Verify(); err != nil {
+			t.Fatal(o, err)
+		}
+
+		dmax := int(math.Round(o.Fill * float64(2*tr.kd)))
+		var keys []int
+		for q := tr.first; q.n != nil && q.n.n != nil; q = q.n {
+			if q.c != dmax {
+				t.Fatal(o, q.c, dmax)
+			}
+
+			keys = append(keys, q.d[0].k)
+		}
+		s := tr.Stats()
+		if math.Abs(s.DataFill-o.Fill) > 0.01 || s.MinDataFill < 0.5 || s.Splits != 0 {
+			t.Fatalf("%+v %+v", o, s)
+		}
+
+		// Insert a key into every data page but the last two.
+		for _, k := range keys {
+			tr.Set(k+1, 0)
+		}
+		switch s2 := tr.Stats(); {
+		case o.Fill < 1 && (s2.Splits != 0 || s2.Borrows != s.Borrows):
+			t.Fatalf("%+v %+v", o, s2)
+		case o.Fill == 1 && s2.Splits+s2.Borrows-s.Borrows < int64(len(keys)):
+			t.Fatalf("%+v %+v", o, s2)
+		}
+	}
+
+	for _, fill := range []float64{-1, 0.4, 1.1, math.NaN()} {
+		if _, err := TreeFromSortedWithOptions[int, int](cmp, seqN(10), LoadOptions{Fill: fill}); err == nil {
+			t.Fatal(fill)
+		}
+	}
+}
+
+func BenchmarkTreeFromSorted1e3(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e3)
+}
+
+func BenchmarkTreeFromSorted1e4(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e4)
+}
+
+func BenchmarkTreeFromSorted1e5(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e5)
+}
+
+func BenchmarkTreeFromSorted1e6(b *testing.B) {
+	benchmarkTreeFromSorted(b, 1e6)
+}
+
+func benchmarkTreeFromSorted(b *testing.B, n int) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		debug.FreeOSMemory()
+		b.StartTimer()
+		r, err := TreeFromSorted[int, int](cmp, seqN(n))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		r.Close()
+	}
+	b.StopTimer()
+}
+
+func TestSplitAt(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 2*kd + 1, 1000, 1e5} {
+		for i := 0; i < 20; i++ {
+			tr := TreeNew[int, int](cmp)
+			for j := 0; j < n; j++ {
+				tr.Set(2*j, -2*j)
+			}
+			k := rng.Next() % (2*n + 4)
+			if i == 0 {
+				k = -1
+			}
+			en, _ := tr.Seek(k)
+			l, r := tr.SplitAt(k)
+			if g, e := tr.Len(), 0; g != e {
+				t.Fatal(n, i, g, e)
+			}
+
+			if _, _, err := en.Next(); err != io.EOF {
+				t.Fatal(n, i, err)
+			}
+
+			nl := 0
+			for j := 0; j < n; j++ {
+				if 2*j < k {
+					nl++
+				}
+			}
+			if g, e := l.Len(), nl; g != e {
+				t.Fatal(n, i, k, g, e)
+			}
+
+			if g, e := r.Len(), n-nl; g != e {
+				t.Fatal(n, i, k, g, e)
+			}
+
+			for _, tr := range []*Tree[int, int]{l, r} {
+				if err := tr.checkCounts(); err != nil {
+					t.Fatal(n, i, err)
+				}
+			}
+
+			j := 0
+			for k, v := range l.All() {
+				if k != 2*j || v != -2*j {
+					t.Fatal(n, i, j, k, v)
+				}
+
+				j++
+			}
+			for k, v := range r.All() {
+				if k != 2*j || v != -2*j {
+					t.Fatal(n, i, j, k, v)
+				}
+
+				j++
+			}
+			if g, e := j, n; g != e {
+				t.Fatal(n, i, g, e)
+			}
+
+			j = n
+			for k := range r.Backward() {
+				j--
+				if k != 2*j {
+					t.Fatal(n, i, j, k)
+				}
+			}
+			for k := range l.Backward() {
+				j--
+				if k != 2*j {
+					t.Fatal(n, i, j, k)
+				}
+			}
+
+			for j := 0; j < n; j++ {
+				l.Set(2*j+1, 0)
+				r.Delete(2 * j)
+			}
+			for _, tr := range []*Tree[int, int]{l, r} {
+				if err := tr.checkCounts(); err != nil {
+					t.Fatal(n, i, err)
+				}
+			}
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 2*kd + 1, 1000, 1e5} {
+		for _, m := range []int{0, 1, 2 * kd, 2*kd + 1, 1000, 1e5} {
+			for i := 0; i < 4; i++ {
+				l := TreeNew[int, int](cmp)
+				r := TreeNew[int, int](cmp)
+				for j := 0; j < n+m; j++ {
+					tr := l
+					if j >= n {
+						tr = r
+					}
+					tr.Set(2*j, -2*j)
+				}
+				for j := 0; j < i*(n+m)/4; j++ {
+					k := 2 * (rng.Next() % (n + m + 1))
+					if j%2 == 0 && k >= 2*n {
+						r.Delete(k)
+						continue
+					}
+
+					l.Delete(k)
+				}
+				nl, nr := l.Len(), r.Len()
+				if nl != 0 {
+					k, _ := l.First()
+					r.Set(k, 0)
+					if err := l.Join(r); err == nil {
+						t.Fatal(n, m, i)
+					}
+
+					if g, e := l.Len(), nl; g != e {
+						t.Fatal(n, m, i, g, e)
+					}
+
+					r.Delete(k)
+				}
+
+				if err := l.Join(r); err != nil {
+					t.Fatal(n, m, i, err)
+				}
+
+				if g, e := r.Len(), 0; g != e {
+					t.Fatal(n, m, i, g, e)
+				}
+
+				if g, e := l.Len(), nl+nr; g != e {
+					t.Fatal(n, m, i, g, e)
+				}
+
+				if err := l.checkCounts(); err != nil {
+					t.Fatal(n, m, i, err)
+				}
+
+				j, last := 0, -1
+				for k := range l.All() {
+					if k <= last {
+						t.Fatal(n, m, i, k, last)
+					}
+
+					last = k
+					j++
+				}
+				for k := range l.Backward() {
+					if k > last {
+						t.Fatal(n, m, i, k, last)
+					}
+
+					last = k
+					j--
+				}
+				if g, e := j, 0; g != e {
+					t.Fatal(n, m, i, g, e)
+				}
+
+				r.Set(-1, 0)
+				for j := 0; j < n+m; j++ {
+					l.Set(2*j+1, 0)
+				}
+				if err := l.checkCounts(); err != nil {
+					t.Fatal(n, m, i, err)
+				}
+			}
+		}
+	}
+}
+
+func TestSetOps(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		for _, m := range []int{0, 1, 2 * kd, 1000, 1e4} {
+			a := TreeNew[int, int](cmp)
+			b := TreeNew[int, int](cmp)
+			ma, mb := map[int]int{}, map[int]int{}
+			for i := 0; i < n; i++ {
+				k := rng.Next() % (n + m + 1)
+				a.Set(k, k)
+				ma[k] = k
+			}
+			for i := 0; i < m; i++ {
+				k := rng.Next() % (n + m + 1)
+				b.Set(k, -k)
+				mb[k] = -k
+			}
+			check := func(s string, tr *Tree[int, int], e map[int]int) {
+				if g, e := tr.Len(), len(e); g != e {
+					t.Fatal(s, n, m, g, e)
+				}
+
+				if err := tr.checkCounts(); err != nil {
+					t.Fatal(s, n, m, err)
+				}
+
+				last := math.MinInt
+				for k, v := range tr.All() {
+					if k <= last {
+						t.Fatal(s, n, m, k, last)
+					}
+
+					if ev, ok := e[k]; !ok || v != ev {
+						t.Fatal(s, n, m, k, v, ev, ok)
+					}
+
+					last = k
+				}
+			}
+
+			e := map[int]int{}
+			for k, v := range ma {
+				e[k] = v
+			}
+			for k, v := range mb {
+				if _, ok := e[k]; ok {
+					v = 2 * k
+				}
+				e[k] = v
+			}
+			check("union", Union(a, b, func(k, va, vb int) int { return va - vb }), e)
+
+			e = map[int]int{}
+			for k, v := range ma {
+				if _, ok := mb[k]; ok {
+					e[k] = v
+				}
+			}
+			check("intersect", Intersect(a, b), e)
+
+			e = map[int]int{}
+			for k, v := range ma {
+				if _, ok := mb[k]; !ok {
+					e[k] = v
+				}
+			}
+			check("difference", Difference(a, b), e)
+
+			if g, e := a.Len(), len(ma); g != e {
+				t.Fatal(n, m, g, e)
+			}
+
+			if g, e := b.Len(), len(mb); g != e {
+				t.Fatal(n, m, g, e)
+			}
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		tr := TreeNew[int, int](cmp)
+		m := map[int]int{}
+		for i := 0; i < n; i++ {
+			k := rng.Next() % (2*n + 1)
+			tr.Set(k, k)
+			m[k] = k
+		}
+		type snapshot struct {
+			s *Tree[int, int]
+			e []int
+		}
+		var a []snapshot
+		take := func() {
+			var e []int
+			for k := range m {
+				e = append(e, k)
+			}
+			sort.Ints(e)
+			a = append(a, snapshot{tr.Snapshot(), e})
+		}
+		check := func(s *Tree[int, int], e []int) {
+			if g, e := s.Len(), len(e); g != e {
+				t.Fatal(n, g, e)
+			}
+
+			if err := s.checkCounts(); err != nil {
+				t.Fatal(n, err)
+			}
+
+			i := 0
+			for k, v := range s.All() {
+				if k != e[i] || v != k {
+					t.Fatal(n, i, k, v, e[i])
+				}
+
+				i++
+			}
+			for k := range s.Backward() {
+				i--
+				if k != e[i] {
+					t.Fatal(n, i, k, e[i])
+				}
+			}
+			if i != 0 {
+				t.Fatal(n, i)
+			}
+
+			if len(e) == 0 {
+				return
+			}
+
+			i = len(e) / 2
+			if k, _ := s.Select(i); k != e[i] {
+				t.Fatal(n, i, k, e[i])
+			}
+
+			if g := s.Rank(e[i]); g != i {
+				t.Fatal(n, i, g)
+			}
+		}
+
+		take()
+		for i := 0; i < 3*n; i++ {
+			k := rng.Next() % (2*n + 1)
+			switch i % 3 {
+			case 0:
+				tr.Set(k, k)
+				m[k] = k
+			case 1:
+				tr.Delete(k)
+				delete(m, k)
+			default:
+				tr.Put(k, func(int, bool) (int, bool) { return k, true })
+				m[k] = k
+			}
+			if i%(n/4+1) == 0 {
+				take()
+			}
+		}
+		tr.DeleteRange(n/2, n)
+		for k := range m {
+			if k >= n/2 && k < n {
+				delete(m, k)
+			}
+		}
+		take()
+		l, r := tr.SplitAt(n / 3)
+		l.Set(math.MinInt, 0)
+		r.Set(math.MaxInt, 0)
+		tr.Clear()
+		for _, v := range a {
+			check(v.s, v.e)
+			v.s.Close()
+		}
+		if g, e := l.Len()+r.Len(), len(m)+2; g != e {
+			t.Fatal(n, g, e)
+		}
+
+		if err := l.checkCounts(); err != nil {
+			t.Fatal(n, err)
+		}
+
+		if err := r.checkCounts(); err != nil {
+			t.Fatal(n, err)
+		}
+	}
+}
+
+func TestSnapshotReadOnly(t *testing.T) {
+	tr := TreeNew[int, int](cmp)
+	tr.Set(1, 1)
+	s := tr.Snapshot()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+
+		if g, e := s.Len(), 1; g != e {
+			t.Fatal(g, e)
+		}
+	}()
+
+	s.Set(2, 2)
+}
+
+func TestTxnBegin(t *testing.T) {
+	tr := NewOrdered[int, int]()
+	x := tr.Begin()
+	if x.w.ord == nil || x.w.kd != tr.kd || x.d.kd != tr.kd || x.d.kx != tr.kx {
+		t.Fatal(x.w.ord == nil, x.w.kd, x.d.kd, x.d.kx)
+	}
+
+	tr = TreeNewWithOptions[int, int](cmp, Options{6, 4})
+	x = tr.Begin()
+	x.Set(1, 10)
+	x.Set(2, 20)
+	tr.Set(1, 100) // Not isolated, overwritten by Commit.
+	tr.Set(3, 300)
+	if v, ok := x.Get(3); !ok || v != 300 {
+		t.Fatal(v, ok)
+	}
+
+	x.Commit()
+	for k, e := range map[int]int{1: 10, 2: 20, 3: 300} {
+		if v, ok := tr.Get(k); !ok || v != e {
+			t.Fatal(k, v, ok, e)
+		}
+	}
+	if x.w.kd != 2 || x.w.kx != 2 {
+		t.Fatal(x.w.kd, x.w.kx)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{1, 2 * kd, 1000} {
+		for _, commit := range []bool{false, true} {
+			tr := TreeNew[int, int](cmp)
+			e := TreeNew[int, int](cmp) // expected content of the txn
+			for i := 0; i < n; i++ {
+				k := rng.Next() % n
+				tr.Set(k, k)
+				e.Set(k, k)
+			}
+			x := tr.Begin()
+			for i := 0; i < n; i++ {
+				k := rng.Next() % n
+				switch i % 3 {
+				case 0:
+					x.Set(k, -k)
+					e.Set(k, -k)
+				case 1:
+					if g, e := x.Delete(k), e.Delete(k); g != e {
+						t.Fatal(n, k, g, e)
+					}
+				default:
+					upd := func(v int, ok bool) (int, bool) { return v + 1, k%2 == 0 }
+					gv, gw := x.Put(k, upd)
+					ev, ew := e.Put(k, upd)
+					if gv != ev || gw != ew {
+						t.Fatal(n, k, gv, gw, ev, ew)
+					}
+				}
+			}
+			for k := -1; k <= n; k++ {
+				gv, gok := x.Get(k)
+				ev, eok := e.Get(k)
+				if gv != ev || gok != eok {
+					t.Fatal(n, k, gv, gok, ev, eok)
+				}
+			}
+			for i := 0; i < 100; i++ {
+				k := rng.Next()%(n+2) - 1
+				g, gok := x.Seek(k)
+				f, eok := e.Seek(k)
+				if gok != eok {
+					t.Fatal(n, k, gok, eok)
+				}
+
+				for j := 0; j < 50; j++ {
+					if j == 25 {
+						k := rng.Next() & (n - 1)
+						x.Delete(k)
+						e.Delete(k)
+					}
+					var gk, gv, ek, ev int
+					var gerr, eerr error
+					switch rng.Next() % 3 {
+					case 0:
+						gk, gv, gerr = g.Prev()
+						ek, ev, eerr = f.Prev()
+					default:
+						gk, gv, gerr = g.Next()
+						ek, ev, eerr = f.Next()
+					}
+					if gk != ek || gv != ev || gerr != eerr {
+						t.Fatal(n, k, j, gk, gv, gerr, ek, ev, eerr)
+					}
+				}
+				g.Close()
+				f.Close()
+			}
+			c := tr.Len()
+			if !commit {
+				x.Rollback()
+				if g, e := tr.Len(), c; g != e {
+					t.Fatal(n, g, e)
+				}
+
+				continue
+			}
+
+			x.Commit()
+			if g, e := tr.Len(), e.Len(); g != e {
+				t.Fatal(n, g, e)
+			}
+
+			for k, v := range e.All() {
+				if g, ok := tr.Get(k); !ok || g != v {
+					t.Fatal(n, k, g, ok, v)
+				}
+			}
+		}
+	}
+}
+
+func TestSyncTree(t *testing.T) {
+	const n = 10000
+	tr := SyncTreeNew[int, int](cmp)
+	for i := 0; i < n; i += 2 {
+		tr.Set(i, i)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) { // writer: odd keys only
+			defer wg.Done()
+			for i := 2*g + 1; i < n; i += 8 {
+				tr.Set(i, i)
+				tr.Put(i, func(v int, ok bool) (int, bool) { return v, ok })
+				tr.Delete(i)
+			}
+		}(g)
+		go func() { // reader: all even keys must be seen, in order
+			defer wg.Done()
+			last, c := -1, 0
+			for k, v := range tr.All() {
+				if k <= last || k != v {
+					errs <- fmt.Errorf("%v %v %v", last, k, v)
+					return
+				}
+
+				if k%2 == 0 {
+					c++
+				}
+				last = k
+			}
+			if c != n/2 {
+				errs <- fmt.Errorf("got %v even keys", c)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if g, e := tr.Len(), n/2; g != e {
+		t.Fatal(g, e)
+	}
+
+	s := tr.Snapshot()
+	if g, e := tr.DeleteRange(0, n), n/2; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := s.Len(), n/2; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestConcurrentTree(t *testing.T) {
+	const g, n = 8, 10000
+	tr := ConcurrentTreeNew[int, int](cmp)
+	var wg sync.WaitGroup
+	errs := make(chan error, g)
+	for i := 0; i < g; i++ {
+		wg.Add(2)
+		go func(i int) { // writer: keys k%g == i only
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				k := j*g + i
+				tr.Set(k, k)
+				if j%3 == 0 {
+					tr.Delete(k)
+				}
+				tr.Put(-1, func(v int, ok bool) (int, bool) { return v + 1, true })
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				k := j*g + (i+1)%g
+				if v, ok := tr.Get(k); ok && v != k {
+					errs <- fmt.Errorf("%v: %v", k, v)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if g, e := tr.Len(), g*(n-(n+2)/3)+1; g != e {
+		t.Fatal(g, e)
+	}
+
+	if v, _ := tr.Get(-1); v != g*n {
+		t.Fatal(v, g*n)
+	}
+
+	for k := 0; k < g*n; k++ {
+		_, ok := tr.Get(k)
+		if g, e := ok, k/g%3 != 0; g != e {
+			t.Fatal(k, g, e)
+		}
+	}
+}
+
+func TestConcurrentTreePutPanic(t *testing.T) {
+	tr := ConcurrentTreeNew[int, int](cmp)
+	tr.Set(1, 1)
+	func() {
+		defer func() { recover() }()
+		tr.Put(1, func(int, bool) (int, bool) { panic("upd") })
+	}()
+	// No page is left locked.
+	tr.Set(1, 2)
+	if v, ok := tr.Get(1); !ok || v != 2 {
+		t.Fatal(v, ok)
+	}
+}
+
+func BenchmarkConcurrentSetRnd(b *testing.B) {
+	t := ConcurrentTreeNew[int, int](cmp)
+	var seed atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rng()
+		rng.Seed(seed.Add(1))
+		for pb.Next() {
+			t.Set(rng.Next(), 0)
+		}
+	})
+}
+
+func BenchmarkSyncSetRnd(b *testing.B) {
+	t := SyncTreeNew[int, int](cmp)
+	var seed atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rng()
+		rng.Seed(seed.Add(1))
+		for pb.Next() {
+			t.Set(rng.Next(), 0)
+		}
+	})
+}
+
+// BenchmarkParallel compares ConcurrentTree with the SyncTree baseline under
+// the same parallel load, 90% Get and 10% Set of random keys in a tree of
+// 1<<17 items. Run it with eg. -cpu 1,2,4,8 to see how the trees scale.
+func BenchmarkParallel(b *testing.B) {
+	type tree interface {
+		Get(int) (int, bool)
+		Set(int, int)
+	}
+
+	const n = 1 << 17
+	for _, c := range []struct {
+		name string
+		t    tree
+	}{
+		{"ConcurrentTree", ConcurrentTreeNew[int, int](cmp)},
+		{"SyncTree", SyncTreeNew[int, int](cmp)},
+	} {
+		for i := 0; i < n; i++ {
+			c.t.Set(i, i)
+		}
+		b.Run(c.name, func(b *testing.B) {
+			var seed atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rng()
+				rng.Seed(seed.Add(1))
+				for pb.Next() {
+					k := rng.Next() & (n - 1)
+					switch {
+					case k%10 == 0:
+						c.t.Set(k, k)
+					default:
+						c.t.Get(k)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestFloorCeil(t *testing.T) {
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		tr := TreeNew[int, int](cmp)
+		var a []int
+		for i := 0; i < n; i++ {
+			tr.Set(3*i, i)
+			a = append(a, 3*i)
+		}
+		s := tr.Snapshot()
+		for _, tr := range []*Tree[int, int]{tr, s} {
+			for k := -2; k < 3*n+2; k++ {
+				i := sort.SearchInts(a, k) // first >= k
+				hit := i < n && a[i] == k
+				check := func(s string, j int, gk, gv int, ok bool) {
+					if j < 0 || j >= n {
+						if ok {
+							t.Fatal(s, n, k, gk, gv)
+						}
+
+						return
+					}
+
+					if !ok || gk != a[j] || gv != j {
+						t.Fatal(s, n, k, gk, gv, ok, a[j], j)
+					}
+				}
+				gk, gv, ok := tr.Ceil(k)
+				check("Ceil", i, gk, gv, ok)
+				j := i + 1
+				if !hit {
+					j = i
+				}
+				gk, gv, ok = tr.Higher(k)
+				check("Higher", j, gk, gv, ok)
+				j = i
+				if !hit {
+					j = i - 1
+				}
+				gk, gv, ok = tr.Floor(k)
+				check("Floor", j, gk, gv, ok)
+				gk, gv, ok = tr.Lower(k)
+				check("Lower", i-1, gk, gv, ok)
+			}
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	for _, n := range []int{0, 1, 2 * kd, 300} {
+		tr := TreeNew[int, int](cmp)
+		for i := 0; i < n; i++ {
+			tr.Set(3*i, i)
+		}
+		for _, tr := range []*Tree[int, int]{tr, tr.Snapshot()} {
+			for lo := -2; lo < 3*n+2; lo += 7 {
+				for hi := lo - 3; hi < 3*n+2; hi += 11 {
+					for _, li := range []bool{false, true} {
+						for _, hii := range []bool{false, true} {
+							var a []int
+							for i := 0; i < n; i++ {
+								k := 3 * i
+								if (k > lo || li && k == lo) && (k < hi || hii && k == hi) {
+									a = append(a, k)
+								}
+							}
+							b := []Bound[int]{LowerBound(lo, li), UpperBound(hi, hii)}
+							e, err := tr.SeekFirst(b...)
+							if len(a) == 0 {
+								if err != io.EOF || e != nil {
+									t.Fatal(n, lo, hi, li, hii, err)
+								}
+
+								continue
+							}
+
+							if err != nil {
+								t.Fatal(n, lo, hi, li, hii, err)
+							}
+
+							for _, k := range a {
+								if g, _, err := e.Next(); err != nil || g != k {
+									t.Fatal(n, lo, hi, li, hii, g, k, err)
+								}
+							}
+							for i := 0; i < 2; i++ {
+								if _, _, err := e.Next(); err != io.EOF {
+									t.Fatal(n, lo, hi, li, hii, err)
+								}
+							}
+							e.Close()
+
+							if e, err = tr.SeekLast(b...); err != nil {
+								t.Fatal(n, lo, hi, li, hii, err)
+							}
+
+							for i := len(a) - 1; i >= 0; i-- {
+								if g, _, err := e.Prev(); err != nil || g != a[i] {
+									t.Fatal(n, lo, hi, li, hii, g, a[i], err)
+								}
+							}
+							if _, _, err := e.Prev(); err != io.EOF {
+								t.Fatal(n, lo, hi, li, hii, err)
+							}
+							e.Close()
+
+							// Reversing the direction stops at the other bound.
+							e, _ = tr.Seek(a[len(a)/2], b...)
+							for i := len(a) / 2; i >= 0; i-- {
+								if g, _, err := e.Prev(); err != nil || g != a[i] {
+									t.Fatal(n, lo, hi, li, hii, g, a[i], err)
+								}
+							}
+							if _, _, err := e.Prev(); err != io.EOF {
+								t.Fatal(n, lo, hi, li, hii, err)
+							}
+							e.Close()
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// The bounds survive a resync after a mutation.
+	tr := TreeNew[int, int](cmp)
+	for i := 0; i < 1000; i++ {
+		tr.Set(i, i)
+	}
+	e, err := tr.SeekFirst(LowerBound(100, false), UpperBound(900, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer e.Close()
+	for i := 101; i <= 900; i++ {
+		k, _, err := e.Next()
+		if err != nil || k != i {
+			t.Fatal(i, k, err)
+		}
+
+		tr.Delete(k - 50)
+		tr.Set(k+1000, k)
+		if k, _, err = e.Next(); err != nil || k != i {
+			t.Fatal(i, k, err)
+		}
+	}
+	if k, _, err := e.Next(); err != io.EOF {
+		t.Fatal(k, err)
+	}
+}
+
+func TestEnumeratorMutation(t *testing.T) {
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		for _, dir := range []int{1, -1} {
+			for _, snap := range []bool{false, true} {
+				tr := TreeNew[int, int](cmp)
+				for i := 0; i < n; i++ {
+					tr.Set(i, i)
+				}
+				var s *Tree[int, int]
+				var sk, sv []int
+				var e *Enumerator[int, int]
+				var err error
+				switch dir {
+				case 1:
+					e, err = tr.SeekFirst()
+				default:
+					e, err = tr.SeekLast()
+				}
+				if err != nil {
+					if n != 0 {
+						t.Fatal(n, err)
+					}
+
+					continue
+				}
+
+				if e.Delete() || e.SetValue(42) {
+					t.Fatal(n, "mutation before Next/Prev")
+				}
+
+				for i := 0; i < n; i++ {
+					var k, v int
+					switch dir {
+					case 1:
+						k, v, err = e.Next()
+					default:
+						k, v, err = e.Prev()
+					}
+					j := i
+					if dir < 0 {
+						j = n - 1 - i
+					}
+					if err != nil || k != j || v != j {
+						t.Fatal(n, dir, snap, i, k, v, err)
+					}
+
+					if snap && i == n/2 {
+						s = tr.Snapshot()
+						for k, v := range tr.All() {
+							sk, sv = append(sk, k), append(sv, v)
+						}
+					}
+					switch {
+					case k%3 == 0:
+						if !e.Delete() || e.Delete() || e.SetValue(42) {
+							t.Fatal(n, dir, snap, k)
+						}
+					default:
+						ver := tr.ver
+						if !e.SetValue(-k) {
+							t.Fatal(n, dir, snap, k)
+						}
+
+						if !snap && tr.ver != ver {
+							t.Fatal(n, dir, snap, k, "SetValue changed the version")
+						}
+					}
+				}
+				if _, _, err = e.Next(); err != io.EOF {
+					t.Fatal(n, dir, snap, err)
+				}
+
+				e.Close()
+				if g, e := tr.Len(), n-(n+2)/3; g != e {
+					t.Fatal(n, dir, snap, g, e)
+				}
+
+				for k, v := range tr.All() {
+					if k%3 == 0 || v != -k {
+						t.Fatal(n, dir, snap, k, v)
+					}
+				}
+				if s != nil {
+					if g, e := s.Len(), len(sk); g != e {
+						t.Fatal(n, dir, snap, g, e)
+					}
+
+					i := 0
+					for k, v := range s.All() {
+						if k != sk[i] || v != sv[i] {
+							t.Fatal(n, dir, snap, k, v, sk[i], sv[i])
+						}
+
+						i++
+					}
+				}
+			}
+		}
+	}
+}
+
+type testAgg struct {
+	n, sum, first, last int
+}
+
+var testMonoid = Monoid[int, int, testAgg]{
+	Combine: func(a, b testAgg) testAgg {
+		switch {
+		case a.n == 0:
+			return b
+		case b.n == 0:
+			return a
+		}
+		return testAgg{a.n + b.n, a.sum + b.sum, a.first, b.last}
+	},
+	Lift: func(k, v int) testAgg { return testAgg{1, v, k, k} },
+}
+
+// checkAggregates verifies the cached aggregates of all pages of t.
+func (t *AugmentedTree[K, V, A]) checkAggregates(q interface{}) (A, error) {
+	m := t.m
+	a := m.Identity
+	switch x := q.(type) {
+	case *x[K, V]:
+		for i := 0; i <= x.c; i++ {
+			b, err := t.checkAggregates(x.x[i].ch)
+			if err != nil {
+				return a, err
+			}
+
+			a = m.Combine(a, b)
+		}
+		if x.a == nil || !reflect.DeepEqual(*x.a.(*A), a) {
+			return a, fmt.Errorf("index page %p: aggregate %v, expected %v", x, x.a, a)
+		}
+	case *d[K, V]:
+		for i := 0; i < x.c; i++ {
+			a = m.Combine(a, m.Lift(x.d[i].k, x.d[i].v))
+		}
+		if x.a == nil || !reflect.DeepEqual(*x.a.(*A), a) {
+			return a, fmt.Errorf("data page %p: aggregate %v, expected %v", x, x.a, a)
+		}
+	}
+	return a, nil
+}
+
+func TestAugmentedTree(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		tr := AugmentedTreeNew[int, int](cmp, testMonoid)
+		check := func(tr *AugmentedTree[int, int, testAgg]) {
+			if _, err := tr.checkAggregates(tr.r); err != nil {
+				t.Fatal(n, err)
+			}
+
+			for i := 0; i < 10; i++ {
+				lo, hi := rng.Next()%(n+1), rng.Next()%(n+1)
+				if i == 0 {
+					lo, hi = math.MinInt32, math.MaxInt32
+				}
+				var e testAgg
+				for k, v := range tr.Range(lo, hi) {
+					e = testMonoid.Combine(e, testMonoid.Lift(k, v))
+				}
+				if g := tr.Aggregate(lo, hi); g != e {
+					t.Fatal(n, lo, hi, g, e)
+				}
+			}
+		}
+		for i := 0; i < n; i++ {
+			k := rng.Next() % (n + 1)
+			tr.Set(k, i)
+		}
+		check(tr)
+		s := tr.Snapshot()
+		sa := s.Aggregate(math.MinInt32, math.MaxInt32)
+		for i := 0; i < n; i++ {
+			k := rng.Next() % (n + 1)
+			switch i % 4 {
+			case 0:
+				tr.Delete(k)
+			case 1:
+				tr.Put(k, func(v int, ok bool) (int, bool) { return v + 1, ok })
+			default:
+				tr.Set(k, -i)
+			}
+		}
+		check(tr)
+		tr.DeleteRange(n/4, n/2)
+		check(tr)
+
+		e, err := tr.SeekFirst()
+		if err == nil {
+			for {
+				k, _, err := e.Next()
+				if err != nil {
+					break
+				}
+
+				switch k % 3 {
+				case 0:
+					e.Delete()
+				case 1:
+					e.SetValue(k)
+				}
+			}
+			e.Close()
+		}
+		check(tr)
+
+		l, r := tr.SplitAt(n / 3)
+		check(&AugmentedTree[int, int, testAgg]{l, tr.m})
+		check(&AugmentedTree[int, int, testAgg]{r, tr.m})
+		if err := l.Join(r); err != nil {
+			t.Fatal(err)
+		}
+
+		tr.Tree = l
+		check(tr)
+		tr.Tree = Union(l, s.Tree, nil)
+		check(tr)
+		check(s)
+		if g, e := s.Aggregate(math.MinInt32, math.MaxInt32), sa; g != e {
+			t.Fatal(n, g, e)
+		}
+	}
+}
+
+func TestMultiTree(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		tr := MultiTreeNew[int, int](cmp)
+		m := map[int][]int{}
+		keys := n/(3*kd) + 1 // Duplicates span many data pages.
+		for i := 0; i < n; i++ {
+			k := rng.Next() % keys
+			tr.Add(k, i)
+			m[k] = append(m[k], i)
+		}
+		check := func() {
+			var ks []int
+			c := 0
+			for k, v := range m {
+				if len(v) != 0 {
+					ks = append(ks, k)
+				}
+				c += len(v)
+				if g, e := tr.GetAll(k), v; len(g) != len(e) || tr.Count(k) != len(e) {
+					t.Fatal(n, k, len(g), len(e), tr.Count(k))
+				}
+
+				for i, w := range tr.GetAll(k) {
+					if w != v[i] {
+						t.Fatal(n, k, i, w, v[i])
+					}
+				}
+				w, ok := tr.Get(k)
+				if ok != (len(v) != 0) || ok && w != v[0] {
+					t.Fatal(n, k, w, ok)
+				}
+			}
+			if g, e := tr.Len(), c; g != e {
+				t.Fatal(n, g, e)
+			}
+
+			sort.Ints(ks)
+			var i, j int
+			for k, v := range tr.All() {
+				for len(m[ks[i]]) == j {
+					i, j = i+1, 0
+				}
+				if k != ks[i] || v != m[k][j] {
+					t.Fatal(n, k, v, ks[i], m[ks[i]][j])
+				}
+
+				j++
+			}
+			i, j = len(ks)-1, -1
+			for k, v := range tr.Backward() {
+				if j < 0 {
+					j = len(m[ks[i]]) - 1
+				}
+				if k != ks[i] || v != m[k][j] {
+					t.Fatal(n, k, v, ks[i], m[ks[i]][j])
+				}
+
+				if j--; j < 0 {
+					i--
+				}
+			}
+			for _, k := range ks {
+				e, ok := tr.Seek(k)
+				if !ok {
+					t.Fatal(n, k)
+				}
+
+				for _, v := range m[k] {
+					if g, w, err := e.Next(); err != nil || g != k || w != v {
+						t.Fatal(n, k, v, g, w, err)
+					}
+				}
+				e.Close()
+			}
+		}
+		check()
+		for k, v := range m {
+			if len(v) == 0 {
+				continue
+			}
+
+			switch k % 3 {
+			case 0:
+				if g, e := tr.DeleteAll(k), len(v); g != e {
+					t.Fatal(n, k, g, e)
+				}
+
+				m[k] = nil
+			default:
+				w := v[len(v)/2]
+				eq := func(v int) bool { return v == w }
+				if !tr.DeleteOne(k, eq) || tr.DeleteOne(k, eq) {
+					t.Fatal(n, k, w)
+				}
+
+				m[k] = append(v[:len(v)/2:len(v)/2], v[len(v)/2+1:]...)
+			}
+		}
+		check()
+
+		e, ok := tr.Seek(keys)
+		if ok {
+			t.Fatal(n, keys)
+		}
+
+		if _, _, err := e.Next(); err != io.EOF {
+			t.Fatal(n, err)
+		}
+
+		e.Close()
+	}
+}
+
+func TestMultiTreeNotComparable(t *testing.T) {
+	tr := MultiTreeNew[int, []byte](cmp)
+	for _, s := range []string{"a", "b", "a"} {
+		tr.Add(1, []byte(s))
+	}
+	eq := func(v []byte) bool { return string(v) == "a" }
+	if !tr.DeleteOne(1, eq) || !tr.DeleteOne(1, eq) || tr.DeleteOne(1, eq) {
+		t.Fatal(tr.GetAll(1))
+	}
+
+	if g := tr.GetAll(1); len(g) != 1 || string(g[0]) != "b" {
+		t.Fatal(g)
+	}
+}
+
+func TestSet(t *testing.T) {
+	if g, e := unsafe.Sizeof(de[int, struct{}]{}), unsafe.Sizeof(0); g != e {
+		t.Fatal(g, e)
+	}
+
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		a, b := SetNew[int](cmp), SetNew[int](cmp)
+		ma, mb := map[int]bool{}, map[int]bool{}
+		for i := 0; i < n; i++ {
+			k := rng.Next() % (2*n + 1)
+			if g, e := a.Add(k), !ma[k]; g != e {
+				t.Fatal(n, k, g, e)
+			}
+
+			ma[k] = true
+			k = rng.Next() % (2*n + 1)
+			b.Add(k)
+			mb[k] = true
+		}
+		for i := 0; i < n/4; i++ {
+			k := rng.Next() % (2*n + 1)
+			if g, e := a.Remove(k), ma[k]; g != e {
+				t.Fatal(n, k, g, e)
+			}
+
+			delete(ma, k)
+		}
+		check := func(s *Set[int], f func(k int) bool) {
+			var e []int
+			for k := -2*n - 1; k <= 2*n+1; k++ {
+				if f(k) {
+					e = append(e, k)
+				}
+				if g, e := s.Has(k), f(k); g != e {
+					t.Fatal(n, k, g, e)
+				}
+			}
+			if g, e := s.Len(), len(e); g != e {
+				t.Fatal(n, g, e)
+			}
+
+			i := 0
+			for k := range s.All() {
+				if k != e[i] {
+					t.Fatal(n, i, k, e[i])
+				}
+
+				i++
+			}
+			for k := range s.Backward() {
+				i--
+				if k != e[i] {
+					t.Fatal(n, i, k, e[i])
+				}
+			}
+			min, ok := s.Min()
+			max, ok2 := s.Max()
+			switch {
+			case len(e) == 0:
+				if ok || ok2 {
+					t.Fatal(n, min, max)
+				}
+			default:
+				if !ok || !ok2 || min != e[0] || max != e[len(e)-1] {
+					t.Fatal(n, min, max, ok, ok2)
+				}
+			}
+		}
+		check(a, func(k int) bool { return ma[k] })
+		check(b, func(k int) bool { return mb[k] })
+		check(a.Union(b), func(k int) bool { return ma[k] || mb[k] })
+		check(a.Intersect(b), func(k int) bool { return ma[k] && mb[k] })
+		check(a.Difference(b), func(k int) bool { return ma[k] && !mb[k] })
+	}
+}
+
+func testNewOrdered[K stdcmp.Ordered](t *testing.T, keys []K) {
+	a, b := NewOrdered[K, int](), TreeNew[K, int](stdcmp.Compare[K])
+	for i, k := range keys {
+		a.Set(k, i)
+		b.Set(k, i)
+		if i%3 == 0 {
+			k := keys[i/2]
+			if g, e := a.Delete(k), b.Delete(k); g != e {
+				t.Fatal(i, k, g, e)
+			}
+		}
+	}
+	if g, e := a.Len(), b.Len(); g != e {
+		t.Fatal(g, e)
+	}
+
+	for _, k := range keys {
+		gv, gok := a.Get(k)
+		ev, eok := b.Get(k)
+		if gv != ev || gok != eok {
+			t.Fatal(k, gv, gok, ev, eok)
+		}
+
+		gk, gv, gok := a.Ceil(k)
+		ek, ev, eok := b.Ceil(k)
+		if stdcmp.Compare(gk, ek) != 0 || gv != ev || gok != eok {
+			t.Fatal(k, gk, gv, gok, ek, ev, eok)
+		}
+
+		if g, e := a.Rank(k), b.Rank(k); g != e {
+			t.Fatal(k, g, e)
+		}
+	}
+	var ga, gb []K
+	for k := range a.All() {
+		ga = append(ga, k)
+	}
+	for k := range b.All() {
+		gb = append(gb, k)
+	}
+	if len(ga) != len(gb) {
+		t.Fatal(len(ga), len(gb))
+	}
+
+	for i := range ga {
+		if stdcmp.Compare(ga[i], gb[i]) != 0 {
+			t.Fatal(i, ga[i], gb[i])
+		}
+	}
+}
+
+func TestNewOrdered(t *testing.T) {
+	rng := rng()
+	const n = 10000
+	var ints []int
+	var strs []string
+	var floats []float64
+	for i := 0; i < n; i++ {
+		k := rng.Next() % n
+		ints = append(ints, k)
+		strs = append(strs, fmt.Sprint(k))
+		f := float64(k) / 7
+		switch i % 100 {
+		case 0:
+			f = math.NaN()
+		case 1:
+			f = math.Inf(1)
+		case 2:
+			f = math.Inf(-1)
+		}
+		floats = append(floats, f)
+	}
+	testNewOrdered(t, ints)
+	testNewOrdered(t, strs)
+	testNewOrdered(t, floats)
+}
+
+func TestOptions(t *testing.T) {
+	for _, test := range []struct {
+		o      Options
+		kx, kd int
+	}{
+		{Options{}, kx, kd},
+		{Options{6, 4}, 2, 2},
+		{Options{7, 5}, 2, 2},
+		{Options{1, 1}, 2, 2},
+		{Options{10, 200}, 4, 100},
+		{Options{200, 6}, 99, 3},
+	} {
+		rng := rng()
+		tr := TreeNewWithOptions[int, int](cmp, test.o)
+		if tr.kx != test.kx || tr.kd != test.kd {
+			t.Fatal(test.o, tr.kx, tr.kd, test.kx, test.kd)
+		}
+
+		m := map[int]int{}
+		const n = 10000
+		for i := 0; i < n; i++ {
+			k := rng.Next() % (n / 4)
+			switch i % 3 {
+			case 2:
+				if g, e := tr.Delete(k), m[k] != 0; g != e {
+					t.Fatal(test.o, i, k, g, e)
+				}
+
+				delete(m, k)
+			default:
+				tr.Set(k, i+1)
+				m[k] = i + 1
+			}
+		}
+		tr.DeleteRange(-n/8, n/8)
+		for k := -n / 8; k < n/8; k++ {
+			delete(m, k)
+		}
+		l, r := tr.SplitAt(0)
+		if l.kx != test.kx || l.kd != test.kd || r.kx != test.kx || r.kd != test.kd {
+			t.Fatal(test.o, l.kx, l.kd, r.kx, r.kd)
+		}
+
+		if err := l.Join(r); err != nil {
+			t.Fatal(test.o, err)
+		}
+
+		tr = l
+		if g, e := tr.Len(), len(m); g != e {
+			t.Fatal(test.o, g, e)
+		}
+
+		if err := tr.checkCounts(); err != nil {
+			t.Fatal(test.o, err)
+		}
+
+		var ks []int
+		for k := range m {
+			ks = append(ks, k)
+		}
+		sort.Ints(ks)
+		i := 0
+		for k, v := range tr.All() {
+			if k != ks[i] || v != m[k] {
+				t.Fatal(test.o, i, k, v, ks[i], m[ks[i]])
+			}
+
+			i++
+		}
+		if i != len(ks) {
+			t.Fatal(test.o, i, len(ks))
+		}
+
+		o := TreeNewWithOptions[int, int](cmp, Options{IndexFanout: 2*test.kx + 4})
+		o.Set(n, n)
+		if err := tr.Join(o); err == nil {
+			t.Fatal(test.o)
+		}
+	}
+}
+
+func TestJoinIncompatible(t *testing.T) {
+	aug := func() *Tree[int, int] {
+		tr := AugmentedTreeNew[int, int](cmp, testMonoid)
+		tr.Set(100, 100)
+		return tr.Tree
+	}
+	sum := Monoid[int, int, int]{Combine: func(a, b int) int { return a + b }, Lift: func(k, v int) int { return v }}
+	for i, c := range []struct {
+		t, o *Tree[int, int]
+		ok   bool
+	}{
+		{TreeNew[int, int](cmp), TreeNewWithOptions[int, int](cmp, Options{LeafFanout: 2*kd + 2}), false},
+		{TreeNew[int, int](cmp), TreeNewWithOptions[int, int](cmp, Options{IndexFanout: 2*kx + 4}), false},
+		{TreeNew[int, int](cmp), aug(), false},
+		{AugmentedTreeNew[int, int](cmp, testMonoid).Tree, TreeNew[int, int](cmp), false},
+		{AugmentedTreeNew[int, int](cmp, sum).Tree, aug(), false},
+		{AugmentedTreeNew[int, int](cmp, testMonoid).Tree, aug(), true},
+		{TreeNew[int, int](cmp), TreeNew[int, int](cmp), true},
+	} {
+		c.t.Set(1, 1)
+		n := c.o.Len()
+		if err := c.t.Join(c.o); (err == nil) != c.ok {
+			t.Fatal(i, err)
+		}
+
+		if !c.ok && (c.t.Len() != 1 || c.o.Len() != n) {
+			t.Fatal(i, c.t.Len(), c.o.Len())
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	rng := rng()
+	for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+		tr := TreeNew[int, string](cmp)
+		for i := 0; i < n; i++ {
+			k := rng.Next()
+			tr.Set(k, fmt.Sprint(k))
+		}
+		var b bytes.Buffer
+		if err := tr.Snapshot().Encode(&b, VarintCodec[int]{}, StringCodec[string]{}); err != nil {
+			t.Fatal(n, err)
+		}
+
+		enc := b.Bytes()
+		u, err := TreeDecode[int, string](bytes.NewReader(enc), cmp, VarintCodec[int]{}, StringCodec[string]{})
+		if err != nil {
+			t.Fatal(n, err)
+		}
+
+		if g, e := u.Len(), tr.Len(); g != e {
+			t.Fatal(n, g, e)
+		}
+
+		if err := u.checkCounts(); err != nil {
+			t.Fatal(n, err)
+		}
+
+		e, _ := tr.SeekFirst()
+		for k, v := range u.All() {
+			ek, ev, err := e.Next()
+			if err != nil || k != ek || v != ev {
+				t.Fatal(n, k, v, ek, ev, err)
+			}
+		}
+		if e != nil {
+			e.Close()
+		}
+
+		// A non io.ByteReader input.
+		if _, err = TreeDecode[int, string](struct{ io.Reader }{bytes.NewReader(enc)}, cmp, VarintCodec[int]{}, StringCodec[string]{}); err != nil {
+			t.Fatal(n, err)
+		}
+
+		for i := 0; i < len(enc); i += len(enc)/7 + 1 {
+			if _, err = TreeDecode[int, string](bytes.NewReader(enc[:i]), cmp, VarintCodec[int]{}, StringCodec[string]{}); err == nil {
+				t.Fatal(n, i, "truncated input accepted")
+			}
+
+			c := append([]byte(nil), enc...)
+			c[i] ^= 0x40
+			if _, err = TreeDecode[int, string](bytes.NewReader(c), cmp, VarintCodec[int]{}, StringCodec[string]{}); err == nil {
+				t.Fatal(n, i, "corrupted input accepted")
+			}
+		}
+	}
+
+	tr := TreeNew[uint64, time.Time](func(a, b uint64) int { return stdcmp.Compare(a, b) })
+	for i := 0; i < 1000; i++ {
+		tr.Set(uint64(i)*1e9, time.Unix(int64(i), 0).UTC())
+	}
+	var b bytes.Buffer
+	if err := tr.Encode(&b, UvarintCodec[uint64]{}, BinaryCodec[time.Time, *time.Time]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := TreeDecode[uint64, time.Time](&b, tr.cmp, UvarintCodec[uint64]{}, BinaryCodec[time.Time, *time.Time]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if v, ok := u.Get(uint64(i) * 1e9); !ok || !v.Equal(time.Unix(int64(i), 0)) {
+			t.Fatal(i, v, ok)
+		}
+	}
+}
+
+func TestCodecBlockSize(t *testing.T) {
+	hdr := binary.AppendUvarint([]byte(codecMagic), codecVersion)
+	for _, size := range []uint64{math.MaxInt32, math.MaxInt32 + 1, 1 << 62} {
+		for _, tail := range []int{0, 1, 1000} {
+			b := binary.AppendUvarint(binary.AppendUvarint(hdr, 1), size)
+			b = append(b, make([]byte, tail)...)
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			alloc := ms.TotalAlloc
+			if _, err := TreeDecode[int, int](bytes.NewReader(b), cmp, VarintCodec[int]{}, VarintCodec[int]{}); err == nil {
+				t.Fatal(size, tail, "oversized block accepted")
+			}
+
+			runtime.ReadMemStats(&ms)
+			if g := ms.TotalAlloc - alloc; g > 1<<20 {
+				t.Fatal(size, tail, g)
+			}
+		}
+	}
+
+	// A truncated block header.
+	b := binary.AppendUvarint(hdr, 1)
+	for _, c := range [][]byte{b, append(b, 0x80), append(b, 0x80, 0x80)} {
+		if _, err := TreeDecode[int, int](bytes.NewReader(c), cmp, VarintCodec[int]{}, VarintCodec[int]{}); err == nil {
+			t.Fatal(c, "truncated header accepted")
+		}
+	}
+}
+
+func TestTreeMarshaler(t *testing.T) {
+	tr := TreeNew[int, string](cmp)
+	for i := 0; i < 1000; i++ {
+		tr.Set(3*i, fmt.Sprint(i))
+	}
+	var m encoding.BinaryMarshaler = TreeMarshalerNew(tr.Snapshot(), VarintCodec[int]{}, StringCodec[string]{})
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := TreeNewWithOptions[int, string](cmp, Options{6, 4})
+	um := TreeMarshalerNew(u, VarintCodec[int]{}, StringCodec[string]{})
+	u.Set(1, "x")
+	s := u.Snapshot()
+	if err := um.UnmarshalBinary(append(data[:len(data):len(data)], 0)); err == nil {
+		t.Fatal("trailing data accepted")
+	}
+
+	if err := um.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("truncated data accepted")
+	}
+
+	if v, ok := u.Get(1); u.Len() != 1 || !ok || v != "x" {
+		t.Fatal(u.Len(), v, ok)
+	}
+
+	var bu encoding.BinaryUnmarshaler = um
+	if err := bu.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if u.kd != 2 || u.kx != 2 {
+		t.Fatal(u.kd, u.kx)
+	}
+
+	if g, e := u.Len(), tr.Len(); g != e {
+		t.Fatal(g, e)
+	}
+
+	i := 0
+	for k, v := range u.All() {
+		if k != 3*i || v != fmt.Sprint(i) {
+			t.Fatal(i, k, v)
+		}
+
+		i++
+	}
+
+	// The snapshot taken before is not affected.
+	if v, ok := s.Get(1); s.Len() != 1 || !ok || v != "x" {
+		t.Fatal(s.Len(), v, ok)
+	}
+
+	// The unmarshaled tree is mutable and marshals back the same items.
+	u.Set(1, "y")
+	u.Delete(1)
+	if data, err = um.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TreeMarshalerNew(tr, VarintCodec[int]{}, StringCodec[string]{}).UnmarshalBinary(data); err != nil || tr.Len() != u.Len() {
+		t.Fatal(err, tr.Len(), u.Len())
+	}
+}
+
+func checkFileTree(tr *FileTree[int, string], m map[int]string) error {
+	if g, e := tr.Len(), len(m); g != e {
+		return fmt.Errorf("Len %d, expected %d", g, e)
+	}
+
+	var keys []int
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	for i, k := range keys {
+		if i%7 != 0 {
+			continue
+		}
+
+		if v, ok, err := tr.Get(k); err != nil || !ok || v != m[k] {
+			return fmt.Errorf("Get(%d): %q %v %v, expected %q", k, v, ok, err, m[k])
+		}
+
+		if _, ok, err := tr.Get(k + 1); err != nil || ok != (i+1 < len(keys) && keys[i+1] == k+1) {
+			return fmt.Errorf("Get(%d): %v %v", k+1, ok, err)
+		}
+	}
+
+	e, err := tr.SeekFirst()
+	if len(keys) == 0 {
+		if err != io.EOF {
+			return fmt.Errorf("SeekFirst of an empty tree: %v", err)
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		k, v, err := e.Next()
+		if err == io.EOF {
+			if i != len(keys) {
+				return fmt.Errorf("Next: got %d items, expected %d", i, len(keys))
+			}
+
+			break
+		}
+
+		if err != nil || k != keys[i] || v != m[k] {
+			return fmt.Errorf("Next #%d: %d %q %v", i, k, v, err)
+		}
+	}
+
+	if e, err = tr.SeekLast(); err != nil {
+		return err
+	}
+
+	for i := len(keys) - 1; ; i-- {
+		k, v, err := e.Prev()
+		if err == io.EOF {
+			if i != -1 {
+				return fmt.Errorf("Prev: %d items left", i+1)
+			}
+
+			return nil
+		}
+
+		if err != nil || k != keys[i] || v != m[k] {
+			return fmt.Errorf("Prev #%d: %d %q %v", i, k, v, err)
+		}
+	}
+}
+
+func TestFileTree(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "tree")
+	open := func() *FileTree[int, string] {
+		f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tr, err := FileTreeOpen[int, string](f, cmp, VarintCodec[int]{}, StringCodec[string]{}, &FileOptions{PageSize: 512, CacheSize: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tr
+	}
+
+	rng := rng()
+	tr := open()
+	if err := tr.Set(1, strings.Repeat("x", 200)); err == nil {
+		t.Fatal("item too big accepted")
+	}
+
+	m := map[int]string{}
+	committed := map[int]string{}
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 3000; i++ {
+			k := rng.Next() % 5000
+			switch {
+			case rng.Next()%3 == 0 || round == 5:
+				ok, err := tr.Delete(k)
+				if err != nil {
+					t.Fatal(round, i, err)
+				}
+
+				_, ok2 := m[k]
+				if ok != ok2 {
+					t.Fatal(round, i, k, ok, ok2)
+				}
+
+				delete(m, k)
+			default:
+				v := strings.Repeat(fmt.Sprint(k), rng.Next()&7)
+				if err := tr.Set(k, v); err != nil {
+					t.Fatal(round, i, err)
+				}
+
+				m[k] = v
+			}
+			if i%1000 == 999 {
+				if err := tr.Commit(); err != nil {
+					t.Fatal(round, i, err)
+				}
+
+				committed = map[int]string{}
+				for k, v := range m {
+					committed[k] = v
+				}
+			}
+		}
+		if err := checkFileTree(tr, m); err != nil {
+			t.Fatal(round, err)
+		}
+
+		// The changes since the last Commit are discarded.
+		if err := tr.Set(-1, ""); err != nil {
+			t.Fatal(round, err)
+		}
+
+		if err := tr.Close(); err != nil {
+			t.Fatal(round, err)
+		}
+
+		tr = open()
+		m = committed
+		if err := checkFileTree(tr, m); err != nil {
+			t.Fatal(round, err)
+		}
+	}
+
+	// Delete everything, the freed pages are reused.
+	for k := range m {
+		if ok, err := tr.Delete(k); !ok || err != nil {
+			t.Fatal(k, ok, err)
+		}
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkFileTree(tr, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := tr.pages
+	for k := range m {
+		if err := tr.Set(k, m[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := tr.pages, pages; g > e {
+		t.Fatal(g, e)
+	}
+
+	// Enumerating while mutating.
+	e, err := tr.SeekFirst()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for {
+		k, _, err := e.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n++
+		if _, err := tr.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tr.Set(k-1e4, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g, e := n, len(m); g != e {
+		t.Fatal(g, e)
+	}
+
+	// Resync at the end of a data page.
+	if e, err = tr.SeekFirst(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := tr.page(e.q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := tr.page(p.next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, prev, next := p.ks[len(p.ks)-1], p.ks[len(p.ks)-2], q.ks[0]
+	if e, _, err = tr.Seek(k); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, _, err := e.Next(); err != nil || g != k {
+		t.Fatal(g, k, err)
+	}
+
+	if _, err := tr.Delete(k); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, _, err := e.Prev(); err != nil || g != prev {
+		t.Fatal(g, prev, err)
+	}
+
+	if e, _, err = tr.Seek(prev); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, _, err := e.Next(); err != nil || g != prev {
+		t.Fatal(g, prev, err)
+	}
+
+	if _, err := tr.Delete(prev); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, _, err := e.Next(); err != nil || g != next {
+		t.Fatal(g, next, err)
+	}
+
+	root := tr.root
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupted page is detected.
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteAt([]byte{0xff}, int64(root)*512+100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr = open()
+	defer tr.Close()
+	if err := checkFileTree(tr, m); err == nil {
+		t.Fatal("corrupted page not detected")
+	}
+}
+
+var errFault = fmt.Errorf("injected fault")
+
+// faultFile is an in-memory File failing all writes after budget bytes were
+// written. The failing write is applied up to the budget, the way a crash
+// leaves a torn write behind. All reads fail while rfail is set.
+type faultFile struct {
+	b      []byte
+	budget int
+	rfail  bool
+}
+
+type faultFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi faultFileInfo) Size() int64 { return fi.size }
+
+func (f *faultFile) Close() error { return nil }
+
+func (f *faultFile) ReadAt(b []byte, off int64) (n int, err error) {
+	if f.rfail {
+		return 0, errFault
+	}
+
+	if off >= int64(len(f.b)) {
+		return 0, io.EOF
+	}
+
+	if n = copy(b, f.b[off:]); n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *faultFile) Stat() (os.FileInfo, error) { return faultFileInfo{size: int64(len(f.b))}, nil }
+
+func (f *faultFile) Sync() error { return nil }
+
+func (f *faultFile) Truncate(size int64) error {
+	if size < int64(len(f.b)) {
+		f.b = f.b[:size]
+	}
+	return nil
+}
+
+func (f *faultFile) WriteAt(b []byte, off int64) (n int, err error) {
+	if len(b) > f.budget {
+		b, err = b[:f.budget], errFault
+	}
+	f.budget -= len(b)
+	if end := int(off) + len(b); end > len(f.b) {
+		f.b = append(f.b, make([]byte, end-len(f.b))...)
+	}
+	return copy(f.b[off:], b), err
+}
+
+func TestLogTree(t *testing.T) {
+	type op struct {
+		del, put bool
+		k        int
+		v        string
+	}
+	var ops []op
+	rng := rng()
+	for i := 0; i < 300; i++ {
+		k := rng.Next() & 63
+		switch rng.Next() % 3 {
+		case 0:
+			ops = append(ops, op{del: true, k: k})
+		case 1:
+			ops = append(ops, op{put: true, k: k})
+		default:
+			ops = append(ops, op{k: k, v: fmt.Sprint(i)})
+		}
+	}
+	kc, vc := VarintCodec[int]{}, StringCodec[string]{}
+	// run executes ops until the first failure and returns the content of
+	// the log, the last checkpoint image and the expected content of the
+	// recovered tree. done reports whether all ops succeeded.
+	run := func(budget int, sync bool) (log, img []byte, m map[int]string, done bool) {
+		f := &faultFile{budget: budget}
+		m = map[int]string{}
+		l, err := LogTreeOpen(TreeNew[int, string](cmp), f, kc, vc, &LogOptions{Sync: sync})
+		if err != nil {
+			return f.b, nil, m, false
+		}
+
+		for i, op := range ops {
+			switch {
+			case op.del:
+				ok, err := l.Delete(op.k)
+				if err != nil {
+					return f.b, img, m, false
+				}
+
+				if _, ok2 := m[op.k]; ok != ok2 {
+					t.Fatal(budget, i, ok, ok2)
+				}
+
+				delete(m, op.k)
+			case op.put:
+				old, written, err := l.Put(op.k, func(v string, exists bool) (string, bool) { return v + "+", exists })
+				if err != nil {
+					return f.b, img, m, false
+				}
+
+				v, ok := m[op.k]
+				if written != ok || old != v {
+					t.Fatal(budget, i, old, written, v, ok)
+				}
+
+				if ok {
+					m[op.k] = v + "+"
+				}
+			default:
+				if err := l.Set(op.k, op.v); err != nil {
+					return f.b, img, m, false
+				}
+
+				m[op.k] = op.v
+			}
+			if i == len(ops)/2 {
+				if err := l.Checkpoint(func(tr *Tree[int, string]) error {
+					var b bytes.Buffer
+					if err := tr.Encode(&b, kc, vc); err != nil {
+						return err
+					}
+
+					img = b.Bytes()
+					return nil
+				}); err != nil {
+					return f.b, img, m, false
+				}
+			}
+		}
+		return f.b, img, m, true
+	}
+	// check opens the log and checks the recovered tree against m.
+	check := func(log, img []byte, m map[int]string) *LogTree[int, string] {
+		tr := TreeNew[int, string](cmp)
+		if img != nil {
+			var err error
+			if tr, err = TreeDecode[int, string](bytes.NewReader(img), cmp, kc, vc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l, err := LogTreeOpen(tr, &faultFile{b: append([]byte(nil), log...), budget: math.MaxInt}, kc, vc, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := l.Tree().Len(), len(m); g != e {
+			t.Fatal(g, e)
+		}
+
+		for k, v := range l.Tree().All() {
+			if m[k] != v {
+				t.Fatal(k, v, m[k])
+			}
+		}
+		return l
+	}
+
+	for budget := 0; ; budget++ {
+		log, img, m, done := run(budget, budget&1 == 0)
+		l := check(log, img, m)
+
+		// The log is usable after dropping its torn tail.
+		if err := l.Set(-1, "x"); err != nil {
+			t.Fatal(budget, err)
+		}
+
+		m[-1] = "x"
+		check(l.f.(*faultFile).b, img, m)
+		if done {
+			break
+		}
+	}
+
+	// A corrupted record is dropped with all the records after it.
+	log, _, _, _ := run(math.MaxInt, false)
+	c := append([]byte(nil), log...)
+	c[len(c)-1] ^= 1
+	l, err := LogTreeOpen(TreeNew[int, string](cmp), &faultFile{b: c, budget: math.MaxInt}, kc, vc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := l.off, int64(len(log)); g >= e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestFileTreeDeleteReadError(t *testing.T) {
+	kc, vc := VarintCodec[int]{}, StringCodec[string]{}
+	o := &FileOptions{PageSize: 512, CacheSize: 64}
+	f := &faultFile{budget: math.MaxInt}
+	tr, err := FileTreeOpen(f, cmp, kc, vc, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 2000
+	m := map[int]string{}
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		if err := tr.Set(i, v); err != nil {
+			t.Fatal(err)
+		}
+
+		m[i] = v
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	faults := 0
+	for i := 0; i < n; i++ {
+		k := i
+		if i&1 != 0 {
+			k = n - i // Both ends of the data pages.
+		}
+		if _, ok := m[k]; !ok {
+			continue
+		}
+
+		// Only the pages on the path to k are cached.
+		if tr, err = FileTreeOpen(f, cmp, kc, vc, o); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := tr.Get(k); err != nil {
+			t.Fatal(k, err)
+		}
+
+		f.rfail = true
+		ok, err := tr.Delete(k)
+		f.rfail = false
+		if err != nil {
+			if ok {
+				t.Fatal(k, "failed delete reported success")
+			}
+
+			faults++
+			if err := checkFileTree(tr, m); err != nil {
+				t.Fatal(k, err)
+			}
+
+			if ok, err = tr.Delete(k); !ok || err != nil {
+				t.Fatal(k, ok, err)
+			}
+		}
+		delete(m, k)
+		if err := tr.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := checkFileTree(tr, m); err != nil || tr.Len() != 0 {
+		t.Fatal(err, tr.Len())
+	}
+
+	if faults == 0 {
+		t.Fatal("no read fault")
+	}
+}
+
+func TestFileTreeCrash(t *testing.T) {
+	kc, vc := VarintCodec[int]{}, StringCodec[string]{}
+	o := &FileOptions{PageSize: 512, CacheSize: 4}
+	// run commits rounds of changes until the first failure. It returns
+	// the content of the file, the state of the last successful Commit
+	// and the state of the failed one, if any. done reports whether all
+	// rounds were committed. The file is nil if it was not created.
+	run := func(budget int) (b []byte, old, new map[int]string, done bool) {
+		f := &faultFile{budget: budget}
+		tr, err := FileTreeOpen(f, cmp, kc, vc, o)
+		if err != nil {
+			return nil, nil, nil, false
+		}
+
+		rng := rng()
+		m := map[int]string{}
+		for round := 0; round < 6; round++ {
+			old = map[int]string{}
+			for k, v := range m {
+				old[k] = v
+			}
+			for i := 0; i < 100; i++ {
+				k := rng.Next() & 255
+				switch {
+				case rng.Next()&3 == 0 || round == 4:
+					if _, err := tr.Delete(k); err != nil {
+						t.Fatal(budget, err)
+					}
+
+					delete(m, k)
+				default:
+					v := strings.Repeat(fmt.Sprint(k), 1+rng.Next()&7)
+					if err := tr.Set(k, v); err != nil {
+						t.Fatal(budget, err)
+					}
+
+					m[k] = v
+				}
+			}
+			if err := tr.Commit(); err != nil {
+				return f.b, old, m, false
+			}
+		}
+		return f.b, m, nil, true
+	}
+	open := func(b []byte) *FileTree[int, string] {
+		tr, err := FileTreeOpen(&faultFile{b: append([]byte(nil), b...), budget: math.MaxInt}, cmp, kc, vc, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tr
+	}
+
+	for budget := 0; ; budget += 29 {
+		b, old, new, done := run(budget)
+		if b == nil {
+			continue
+		}
+
+		// The file has the state of either the last successful Commit or
+		// the failed one.
+		tr := open(b)
+		m := old
+		if err := checkFileTree(tr, old); err != nil {
+			if new == nil {
+				t.Fatal(budget, err)
+			}
+
+			if err := checkFileTree(tr, new); err != nil {
+				t.Fatal(budget, err)
+			}
+
+			m = new
+		}
+
+		// The recovered tree is usable.
+		for k := 0; k < 256; k += 7 {
+			if err := tr.Set(k, "x"); err != nil {
+				t.Fatal(budget, err)
+			}
+
+			m[k] = "x"
+		}
+		if err := tr.Commit(); err != nil {
+			t.Fatal(budget, err)
+		}
+
+		if err := checkFileTree(open(tr.f.(*faultFile).b), m); err != nil {
+			t.Fatal(budget, err)
+		}
+
+		if done {
+			break
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, o := range []Options{{}, {IndexFanout: 6, LeafFanout: 4}} {
+		for _, n := range []int{0, 1, 2 * kd, 1000, 1e4} {
+			tr := TreeNewWithOptions[int, int](cmp, o)
+			for i := 0; i < n; i++ {
+				tr.Set(10*i, i)
+			}
+			if err := tr.Verify(); err != nil {
+				t.Fatal(o, n, err)
+			}
+
+			s := tr.Snapshot()
+			for i := 0; i < n; i += 3 {
+				tr.Delete(10 * i)
+			}
+			if err := tr.Verify(); err != nil {
+				t.Fatal(o, n, err)
+			}
+
+			if err := s.Verify(); err != nil {
+				t.Fatal(o, n, err)
+			}
+		}
+	}
+
+	corrupt := func(f func(tr *Tree[int, int]), e string) {
+		tr := TreeNew[int, int](cmp)
+		for i := 0; i < 1e4; i++ {
+			tr.Set(10*i, i)
+		}
+		f(tr)
+		err := tr.Verify()
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Fatalf("%v, expected %q", err, e)
+		}
+	}
+	corrupt(func(tr *Tree[int, int]) { tr.c++ }, "Len is 10001")
+	corrupt(func(tr *Tree[int, int]) {
+		q := tr.first.n
+		q.d[0].k, q.d[1].k = q.d[1].k, q.d[0].k
+	}, "key #1")
+	corrupt(func(tr *Tree[int, int]) { tr.first.n.d[0].k = 5 }, "not greater than the previous key")
+	corrupt(func(tr *Tree[int, int]) { tr.r.(*x[int, int]).x[0].ch.(*x[int, int]).x[1].k = 1e6 }, "page root/0: key #1 1000000")
+	corrupt(func(tr *Tree[int, int]) { tr.r.(*x[int, int]).x[1].ch.(*x[int, int]).n++ }, "page root/1: index page subtree")
+	corrupt(func(tr *Tree[int, int]) { tr.last.p = tr.first }, "not linked to the previous data page")
+	corrupt(func(tr *Tree[int, int]) { tr.last = tr.last.p }, "last is not the last data page")
+	corrupt(func(tr *Tree[int, int]) { tr.Set(1, 1); tr.first.c = 1 }, "minimum is")
+}
+
+func TestStats(t *testing.T) {
+	tr := TreeNew[int, int](cmp)
+	if g, e := tr.Stats(), (Stats{Bytes: int64(unsafe.Sizeof(*tr))}); g != e {
+		t.Fatalf("%+v %+v", g, e)
+	}
+
+	rng := rng()
+	const n = 1e5
+	for i := 0; i < n; i++ {
+		tr.Set(rng.Next()%n, i)
+	}
+	s := tr.Stats()
+	if s.Height != tr.height(tr.r)+1 || s.Height < 3 || s.Splits == 0 || s.Borrows == 0 || s.Merges != 0 {
+		t.Fatalf("%+v", s)
+	}
+
+	dp := 0
+	for q := tr.first; q != nil; q = q.n {
+		dp++
+	}
+	if g, e := s.DataPages, dp; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := int(math.Round(s.DataFill*float64(s.DataPages)*2*kd)), tr.Len(); g != e {
+		t.Fatal(g, e)
+	}
+
+	if s.MinDataFill < 0.5 || s.MinDataFill > s.DataFill || s.DataFill > 1 || s.MinIndexFill > s.IndexFill || s.IndexFill > 1 {
+		t.Fatalf("%+v", s)
+	}
+
+	if s.Bytes < int64(s.DataPages)*int64(unsafe.Sizeof(de[int, int]{}))*2*kd {
+		t.Fatalf("%+v", s)
+	}
+
+	for i := 0; i < n; i += 2 {
+		tr.Delete(i)
+	}
+	u := tr.Stats()
+	if u.Merges == 0 || u.Borrows <= s.Borrows || u.Splits != s.Splits || u.DataPages >= s.DataPages {
+		t.Fatalf("%+v %+v", s, u)
+	}
+
+	if g := tr.Snapshot().Stats(); g.Splits != 0 || g.Merges != 0 || g.Borrows != 0 || g.DataPages != u.DataPages {
+		t.Fatalf("%+v", g)
+	}
+
+	tr = TreeNew[int, int](cmp)
+	tr.Set(1, 1)
+	if g := tr.Stats(); g.Height != 1 || g.DataPages != 1 || g.IndexPages != 0 || g.DataFill != 1/float64(2*kd) || g.MinIndexFill != 0 {
+		t.Fatalf("%+v", g)
+	}
+}
+
+func TestDump(t *testing.T) {
+	tr := TreeNewWithOptions[int, int](cmp, Options{IndexFanout: 6, LeafFanout: 4})
+	var buf bytes.Buffer
+	if err := tr.Dump(&buf, nil); err != nil || buf.Len() != 0 {
+		t.Fatal(err, buf.Len())
+	}
+
+	for i := 0; i < 20; i++ {
+		tr.Set(10*i, i)
+	}
+	if err := tr.Dump(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `X#1 c 4 n 20 {C#2 40 C#3 80 C#4 120 C#5 160 C#6}
+. D#2 P#0 N#3 c 4 {0:0 10:1 20:2 30:3}
+. D#3 P#2 N#4 c 4 {40:4 50:5 60:6 70:7}
+. D#4 P#3 N#5 c 4 {80:8 90:9 100:10 110:11}
+. D#5 P#4 N#6 c 4 {120:12 130:13 140:14 150:15}
+. D#6 P#5 N#0 c 4 {160:16 170:17 180:18 190:19}
+`; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	buf.Reset()
+	o := &DumpOptions[int, int]{
+		Key:   func(k int) string { return fmt.Sprintf("k%d", k) },
+		Value: func(v int) string { return fmt.Sprintf("v%d", v) },
+		Path:  []int{1},
+	}
+	if err := tr.Snapshot().Dump(&buf, o); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), "D#1 c 4 {k40:v4 k50:v5 k60:v6 k70:v7}\n"; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	buf.Reset()
+	if err := tr.Dump(&buf, &DumpOptions[int, int]{Depth: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), "X#1 c 4 n 20 {C#2 40 C#3 80 C#4 120 C#5 160 C#6}\n"; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	for _, path := range [][]int{{-1}, {5}, {0, 0}} {
+		if err := tr.Dump(&buf, &DumpOptions[int, int]{Path: path}); err == nil || !strings.Contains(err.Error(), "invalid path") {
+			t.Fatal(path, err)
+		}
+	}
+}
+
+func TestWriteDot(t *testing.T) {
+	tr := TreeNewWithOptions[int, int](cmp, Options{IndexFanout: 6, LeafFanout: 4})
+	for i := 0; i < 3; i++ {
+		tr.Set(i, i)
+	}
+	var buf bytes.Buffer
+	o := &DumpOptions[int, int]{Key: func(k int) string { return fmt.Sprintf("<%d|\"%d\">", k, k) }}
+	if err := tr.WriteDot(&buf, o); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `digraph btree {
+	node [shape=record];
+	D1 [label="\<0\|\"0\"\>: 0|\<1\|\"1\"\>: 1|\<2\|\"2\"\>: 2"];
+}
+`; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	for i := 3; i < 1000; i++ {
+		tr.Set(i, i)
+	}
+	buf.Reset()
+	if err := tr.WriteDot(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	s0 := tr.Stats()
+	if g, e := strings.Count(s, "style=dashed"), s0.DataPages-1; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := strings.Count(s, " -> "), s0.IndexPages+2*s0.DataPages-2; g != e {
+		t.Fatal(g, e)
+	}
+
+	buf.Reset()
+	if err := tr.WriteDot(&buf, &DumpOptions[int, int]{Path: []int{0}, Depth: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	x := tr.r.(*x[int, int]).x[0].ch.(*x[int, int])
+	if s := buf.String(); strings.Count(s, `label="..."`) != x.c+1 || strings.Contains(s, "D") {
+		t.Fatal(s)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+// Every page of an augmented tree caches the aggregate of its items, a *A
+// boxed in the page's a field. A nil a means the aggregate was not computed
+// yet. The mutating methods get every page they modify from own or ch, which
+// invalidate its aggregate, and the pages they create start with no
+// aggregate. The ancestors of such pages are reached the same way, so after a
+// mutation all the pages to recompute are found by descending from the root
+// through the pages having no aggregate only.
+
+// Monoid defines the aggregate of type A maintained by an AugmentedTree.
+// Combine must be associative and Identity must be its identity element. Lift
+// returns the aggregate of a single KV pair. Combine does not have to be
+// commutative, the aggregates are always combined in the key collating order.
+type Monoid[K comparable, V, A interface{}] struct {
+	Identity A
+	Combine  func(a, b A) A
+	Lift     func(k K, v V) A
+}
+
+// aggregator computes the aggregates of pages.
+type aggregator[K comparable, V interface{}] interface {
+	aggD(q *d[K, V]) interface{}
+	aggX(q *x[K, V]) interface{}
+	// like reports whether a computes aggregates of the same type.
+	like(a aggregator[K, V]) bool
+}
+
+func (m *Monoid[K, V, A]) aggD(q *d[K, V]) interface{} {
+	a := m.Identity
+	for i := 0; i < q.c; i++ {
+		e := &q.d[i]
+		a = m.Combine(a, m.Lift(e.k, e.v))
+	}
+	return &a
+}
+
+func (m *Monoid[K, V, A]) aggX(q *x[K, V]) interface{} {
+	a := m.Identity
+	for i := 0; i <= q.c; i++ {
+		a = m.Combine(a, m.of(q.x[i].ch))
+	}
+	return &a
+}
+
+func (m *Monoid[K, V, A]) like(a aggregator[K, V]) bool {
+	_, ok := a.(*Monoid[K, V, A])
+	return ok
+}
+
+// of returns the cached aggregate of page q.
+func (m *Monoid[K, V, A]) of(q interface{}) A {
+	switch x := q.(type) {
+	case *x[K, V]:
+		return *x.a.(*A)
+	default:
+		return *q.(*d[K, V]).a.(*A)
+	}
+}
+
+// sameAug reports whether t and u maintain aggregates of the same type, if
+// any.
+func (t *Tree[K, V]) sameAug(u *Tree[K, V]) bool {
+	if t.aug == nil || u.aug == nil {
+		return t.aug == nil && u.aug == nil
+	}
+
+	return t.aug.like(u.aug)
+}
+
+// augment recomputes the aggregates invalidated by a mutation of t.
+func (t *Tree[K, V]) augment() {
+	if t.aug != nil {
+		t.aggregate(t.r)
+	}
+}
+
+func (t *Tree[K, V]) aggregate(q interface{}) {
+	switch x := q.(type) {
+	case *x[K, V]:
+		if x.a != nil {
+			return
+		}
+
+		for i := 0; i <= x.c; i++ {
+			t.aggregate(x.x[i].ch)
+		}
+		x.a = t.aug.aggX(x)
+	case *d[K, V]:
+		if x.a == nil {
+			x.a = t.aug.aggD(x)
+		}
+	}
+}
+
+// AugmentedTree is a Tree maintaining an aggregate of its KV pairs, defined by
+// a Monoid. The aggregate of every subtree is cached in its root page, so the
+// aggregate of any key range is computed by Aggregate in O(log n). The
+// mutations of the tree update the aggregates of the pages they modify, an
+// update costs O(log n) invocations of Combine and Lift.
+//
+// The trees returned by SplitAt and the set operations on an AugmentedTree
+// keep maintaining the aggregates. A tree joined to an AugmentedTree by Join
+// must be augmented by the same Monoid, Join rejects a tree not augmented or
+// augmented by a Monoid of another type.
+type AugmentedTree[K comparable, V, A interface{}] struct {
+	*Tree[K, V]
+	m *Monoid[K, V, A]
+}
+
+// AugmentedTreeNew returns a newly created, empty AugmentedTree. The compare
+// function is used for key collation, m defines the aggregate.
+func AugmentedTreeNew[K comparable, V, A interface{}](cmp Cmp[K], m Monoid[K, V, A]) *AugmentedTree[K, V, A] {
+	t := TreeNew[K, V](cmp)
+	t.aug = &m
+	return &AugmentedTree[K, V, A]{t, &m}
+}
+
+// Aggregate returns the aggregate of the KV pairs having lo <= key < hi, or
+// the identity of the Monoid if there is no such pair.
+func (t *AugmentedTree[K, V, A]) Aggregate(lo, hi K) A {
+	if t.r == nil || t.cmp(lo, hi) >= 0 {
+		return t.m.Identity
+	}
+
+	return t.fold(t.r, &lo, &hi)
+}
+
+// fold returns the aggregate of the items of the subtree q having lo <= key <
+// hi. A nil lo or hi is not a limit.
+func (t *AugmentedTree[K, V, A]) fold(q interface{}, lo, hi *K) A {
+	m := t.m
+	switch x := q.(type) {
+	case *x[K, V]:
+		if lo == nil && hi == nil {
+			return m.of(x)
+		}
+
+		i, j := 0, x.c
+		if lo != nil {
+			i = t.child(x, *lo)
+		}
+		if hi != nil {
+			j = t.child(x, *hi)
+		}
+		if i == j {
+			return t.fold(x.x[i].ch, lo, hi)
+		}
+
+		a := t.fold(x.x[i].ch, lo, nil)
+		for i++; i < j; i++ {
+			a = m.Combine(a, m.of(x.x[i].ch))
+		}
+		return m.Combine(a, t.fold(x.x[j].ch, nil, hi))
+	case *d[K, V]:
+		if lo == nil && hi == nil {
+			return m.of(x)
+		}
+
+		i, j := 0, x.c
+		if lo != nil {
+			i, _ = t.find(x, *lo)
+		}
+		if hi != nil {
+			j, _ = t.find(x, *hi)
+		}
+		a := m.Identity
+		for ; i < j; i++ {
+			e := &x.d[i]
+			a = m.Combine(a, m.Lift(e.k, e.v))
+		}
+		return a
+	}
+	panic("internal error")
+}
+
+// child returns the index of the child of q covering k.
+func (t *AugmentedTree[K, V, A]) child(q *x[K, V], k K) int {
+	i, ok := t.find(q, k)
+	if ok {
+		i++
+	}
+	return i
+}
+
+// Snapshot returns a read-only view of the current content of t. See
+// Tree.Snapshot.
+func (t *AugmentedTree[K, V, A]) Snapshot() *AugmentedTree[K, V, A] {
+	return &AugmentedTree[K, V, A]{t.Tree.Snapshot(), t.m}
+}
@@ -8,30 +8,50 @@
 //
 // Concurrency considerations
 //
-// Tree.{Clear,Delete,Put,Set} mutate the tree. One can use eg. a
+// Tree.{Clear,Delete,DeleteRange,Join,Put,Set,Snapshot,SplitAt} and
+// TreeMarshaler.UnmarshalBinary mutate the tree. One can use eg. a
 // sync.Mutex.Lock/Unlock (or sync.RWMutex.Lock/Unlock) to wrap those calls if
-// they are to be invoked concurrently.
+// they are to be invoked concurrently. Join mutates also its argument.
+// Enumerator.{Delete,SetValue} mutate the tree as well.
 //
-// Tree.{First,Get,Last,Len,Seek,SeekFirst,SekLast} read but do not mutate the
-// tree.  One can use eg. a sync.RWMutex.RLock/RUnlock to wrap those calls if
-// they are to be invoked concurrently with any of the tree mutating methods.
+// Tree.{All,Backward,Ceil,Dump,Encode,First,Floor,Get,Higher,Last,Len,Lower,
+// Range,Rank,Seek,SeekFirst,SeekLast,Select,Stats,Verify,WriteDot} and
+// TreeMarshaler.MarshalBinary read but do not mutate the tree. The same holds
+// for the trees passed to Union, Intersect and Difference. The iterators
+// returned by All, Backward and Range read the tree on every step, the same
+// way as Enumerator.{Next,Prev} do. Enumerator.{Next,Prev} mutate the
+// enumerator and read but do not mutate the tree.
 //
-// Enumerator.{Next,Prev} mutate the enumerator and read but not mutate the
-// tree.  One can use eg. a sync.RWMutex.RLock/RUnlock to wrap those calls if
-// they are to be invoked concurrently with any of the tree mutating methods. A
-// separate mutex for the enumerator, or the whole tree in a simplified
-// variant, is necessary if the enumerator's Next/Prev methods per se are to
-// be invoked concurrently.
+// One can use eg. a sync.RWMutex.RLock/RUnlock to wrap all the above reading
+// calls if they are to be invoked concurrently with any of the tree mutating
+// methods. A separate mutex for the enumerator, or the whole tree in a
+// simplified variant, is necessary if the enumerator's Next/Prev methods per
+// se are to be invoked concurrently.
+//
+// SyncTree wraps a Tree and its enumerators following the above rules.
+// ConcurrentTree is a separate B+tree variant supporting parallel Get, Set,
+// Put and Delete without a global lock. AugmentedTree is a Tree, its
+// Aggregate method reads but does not mutate the tree. All methods of a
+// FileTree, including Get, update its page cache, so none of them can be
+// invoked concurrently.
+//
+// A snapshot returned by Tree.Snapshot does not change when the tree it was
+// taken from is mutated. Its reading methods, the ones listed above, and its
+// enumerators need no locking against the mutations of that tree.
 package b // import "modernc.org/b/v2"
 
 import (
+	stdcmp "cmp"
 	"io"
+	"iter"
 	"sync"
 )
 
 const (
-	kx = 32
-	kd = 32
+	kx = 32 // Default, see Options.
+	kd = 32 // Default, see Options.
+
+	maxPath = 16 // Initial capacity of the root to leaf paths, not a limit.
 )
 
 type (
@@ -44,15 +64,17 @@ type (
 	Cmp[K comparable] func(a, b K) int
 
 	d[K comparable, V interface{}] struct { // data page
-		c int
-		d [2*kd + 1]de[K, V]
-		n *d[K, V]
-		p *d[K, V]
+		a   interface{} // aggregate of the items, see AugmentedTree
+		c   int
+		d   []de[K, V] // 2*kd+1 items
+		gen uint64
+		n   *d[K, V]
+		p   *d[K, V]
 	}
 
 	de[K comparable, V interface{}] struct { // d element
+		v V // First, so a zero-size V, eg. in Set, needs no padding.
 		k K
-		v V
 	}
 
 	// Enumerator captures the state of enumerating a tree. It is returned
@@ -64,26 +86,42 @@ type (
 	// items", it does no more attempt to "resync" on tree mutation(s).  In
 	// other words, io.EOF from an Enumerator is "sticky" (idempotent).
 	Enumerator[K comparable, V interface{}] struct {
-		err error
-		hit bool
-		i   int
-		k   K
-		q   *d[K, V]
-		t   *Tree[K, V]
-		ver int64
+		bounded bool // hi or lo is set
+		dir     int  // 1: k was returned by Next, -1: k was returned by Prev
+		err     error
+		hi      Bound[K]
+		hit     bool
+		i       int
+		k       K
+		lo      Bound[K]
+		q       *d[K, V]
+		rq      *d[K, V] // page of the item last returned
+		ri      int      // index of the item last returned in rq
+		t       *Tree[K, V]
+		ver     int64
 	}
 
 	// Tree is a B+tree.
 	Tree[K comparable, V interface{}] struct {
-		c     int
-		cmp   Cmp[K]
-		first *d[K, V]
-		last  *d[K, V]
-		r     interface{}
-		ver   int64
-		dPool sync.Pool
-		ePool sync.Pool
-		xPool sync.Pool
+		aug     aggregator[K, V] // see AugmentedTree
+		borrows int64            // see Stats
+		c       int
+		cmp     Cmp[K]
+		first   *d[K, V]
+		gen     uint64 // see Snapshot
+		kd      int
+		kx      int
+		last    *d[K, V]
+		merges  int64                                // see Stats
+		ord     func(q interface{}, k K) (int, bool) // see NewOrdered
+		r       interface{}
+		ro      bool  // t is a snapshot
+		shared  bool  // pages of other generations may be reachable, see own
+		splits  int64 // see Stats
+		ver     int64
+		dPool   sync.Pool
+		ePool   sync.Pool
+		xPool   sync.Pool
 	}
 
 	xe[K comparable] struct { // x element
@@ -92,22 +130,27 @@ type (
 	}
 
 	x[K comparable, V interface{}] struct { // index page
-		c int
-		x [2*kx + 2]xe[K]
+		a   interface{} // aggregate of the subtree, see AugmentedTree
+		c   int
+		gen uint64
+		n   int     // number of items in the subtree
+		x   []xe[K] // 2*kx+2 items
 	}
 )
 
 func (t *Tree[K, V]) clr(q interface{}) {
 	switch px := q.(type) {
 	case *x[K, V]:
+		if px.gen != t.gen { // All pages of the subtree are shared.
+			return
+		}
+
 		for i := 0; i <= px.c; i++ { // Ch0 Sep0 ... Chn-1 Sepn-1 Chn
 			t.clr(px.x[i].ch)
 		}
-		*px = x[K, V]{}
-		t.xPool.Put(px)
+		t.freeX(px)
 	case *d[K, V]:
-		*px = d[K, V]{}
-		t.dPool.Put(px)
+		t.freeD(px)
 	}
 }
 
@@ -115,10 +158,28 @@ func (t *Tree[K, V]) clr(q interface{}) {
 
 func (t *Tree[K, V]) newX(ch0 interface{}) *x[K, V] {
 	r := t.xPool.Get().(*x[K, V])
+	r.gen = t.gen
 	r.x[0].ch = ch0
 	return r
 }
 
+func (q *x[K, V]) count(i int) int {
+	switch ch := q.x[i].ch.(type) {
+	case *x[K, V]:
+		return ch.n
+	case *d[K, V]:
+		return ch.c
+	}
+	return 0
+}
+
+func (q *x[K, V]) sum(from, to int) (n int) {
+	for i := from; i < to; i++ {
+		n += q.count(i)
+	}
+	return n
+}
+
 func (q *x[K, V]) extract(i int) {
 	q.c--
 	if i < q.c {
@@ -158,6 +219,12 @@ func (q *x[K, V]) siblings(i int) (l, r *d[K, V]) {
 
 // -------------------------------------------------------------------------- d
 
+func (t *Tree[K, V]) newD() *d[K, V] {
+	r := t.dPool.Get().(*d[K, V])
+	r.gen = t.gen
+	return r
+}
+
 func (l *d[K, V]) mvL(r *d[K, V], c int) {
 	copy(l.d[l.c:], r.d[:c])
 	copy(r.d[:], r.d[c:r.c])
@@ -177,12 +244,144 @@ func (l *d[K, V]) mvR(r *d[K, V], c int) {
 // TreeNew returns a newly created, empty Tree. The compare function is used
 // for key collation.
 func TreeNew[K comparable, V interface{}](cmp Cmp[K]) *Tree[K, V] {
-	return &Tree[K, V]{
-		cmp:   cmp,
-		dPool: sync.Pool{New: func() interface{} { return &d[K, V]{} }},
-		ePool: sync.Pool{New: func() interface{} { return &Enumerator[K, V]{} }},
-		xPool: sync.Pool{New: func() interface{} { return &x[K, V]{} }},
+	return TreeNewWithOptions[K, V](cmp, Options{})
+}
+
+// Options configures the size of the pages of a Tree.
+type Options struct {
+	// IndexFanout is the maximum number of children of an index page. It is
+	// rounded down to an even number, the minimum is 6. Zero selects the
+	// default of 66.
+	IndexFanout int
+
+	// LeafFanout is the maximum number of KV pairs in a data page. It is
+	// rounded down to an even number, the minimum is 4. Zero selects the
+	// default of 64. Small data pages suit big values, big data pages suit
+	// small keys and values.
+	LeafFanout int
+}
+
+// TreeNewWithOptions returns a newly created, empty Tree having the page sizes
+// selected by o. The compare function is used for key collation.
+func TreeNewWithOptions[K comparable, V interface{}](cmp Cmp[K], o Options) *Tree[K, V] {
+	t := &Tree[K, V]{
+		cmp: cmp,
+		gen: newGen(),
+		kd:  kd,
+		kx:  kx,
+	}
+	if o.IndexFanout != 0 {
+		t.kx = max(o.IndexFanout/2-1, 2)
+	}
+	if o.LeafFanout != 0 {
+		t.kd = max(o.LeafFanout/2, 2)
+	}
+	t.dPool.New = t.allocD
+	t.ePool.New = func() interface{} { return &Enumerator[K, V]{} }
+	t.xPool.New = t.allocX
+	return t
+}
+
+// allocD returns a new data page. Pages of the default size are allocated
+// together with their items.
+func (t *Tree[K, V]) allocD() interface{} {
+	if t.kd == kd {
+		z := &struct {
+			q d[K, V]
+			a [2*kd + 1]de[K, V]
+		}{}
+		z.q.d = z.a[:]
+		return &z.q
 	}
+
+	return &d[K, V]{d: make([]de[K, V], 2*t.kd+1)}
+}
+
+// allocX returns a new index page. Pages of the default size are allocated
+// together with their items.
+func (t *Tree[K, V]) allocX() interface{} {
+	if t.kx == kx {
+		z := &struct {
+			q x[K, V]
+			a [2*kx + 2]xe[K]
+		}{}
+		z.q.x = z.a[:]
+		return &z.q
+	}
+
+	return &x[K, V]{x: make([]xe[K], 2*t.kx+2)}
+}
+
+// NewOrdered returns a newly created, empty Tree of keys having an ordered
+// type. The tree behaves identically to TreeNew(cmp.Compare[K]), but it
+// compares the keys directly when searching the pages instead of calling the
+// compare function for every key.
+func NewOrdered[K stdcmp.Ordered, V interface{}]() *Tree[K, V] {
+	t := TreeNew[K, V](stdcmp.Compare[K])
+	t.ord = findOrdered[K, V]
+	return t
+}
+
+// options returns the Options selecting the page sizes of t.
+func (t *Tree[K, V]) options() Options {
+	return Options{IndexFanout: 2*t.kx + 2, LeafFanout: 2 * t.kd}
+}
+
+// empty returns a newly created, empty tree having the same page sizes as t
+// and collating and aggregating the keys the same way.
+func (t *Tree[K, V]) empty() *Tree[K, V] {
+	z := TreeNewWithOptions[K, V](t.cmp, t.options())
+	z.aug, z.ord = t.aug, t.ord
+	return z
+}
+
+// All returns an iterator over the KV pairs of the tree in the key collating
+// order. The iteration resumes at the proper key if the tree is mutated in the
+// process, the same way as Enumerator.Next does, except that the key yielded
+// last is not yielded again.
+func (t *Tree[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		e, err := t.SeekFirst()
+		if err != nil {
+			return
+		}
+
+		defer e.Close()
+		for n := 0; ; n++ {
+			k, v, err := e.advance(false, n != 0)
+			if err != nil || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the KV pairs of the tree in the reverse
+// key collating order. The iteration resumes at the proper key if the tree is
+// mutated in the process, the same way as Enumerator.Prev does, except that
+// the key yielded last is not yielded again.
+func (t *Tree[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		e, err := t.SeekLast()
+		if err != nil {
+			return
+		}
+
+		defer e.Close()
+		for n := 0; ; n++ {
+			k, v, err := e.advance(true, n != 0)
+			if err != nil || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Ceil returns the item having the smallest key >= k and true, or
+// (zero-value, zero-value, false) if there is no such item.
+func (t *Tree[K, V]) Ceil(k K) (K, V, bool) {
+	q, i, _, _, r := t.near(k)
+	return t.at(q, i, r)
 }
 
 // Clear removes all K/V pairs from the tree.
@@ -192,7 +391,7 @@ func (t *Tree[K, V]) Clear() {
 	}
 
 	t.clr(t.r)
-	t.c, t.first, t.last, t.r = 0, nil, nil, nil
+	t.c, t.first, t.last, t.r, t.shared = 0, nil, nil, nil, false
 	t.ver++
 }
 
@@ -202,42 +401,48 @@ func (t *Tree[K, V]) Close() {
 	*t = Tree[K, V]{}
 }
 
+// adjust updates the item counts of the root page and of the index pages on
+// path by delta.
+func (t *Tree[K, V]) adjust(path []*x[K, V], delta int) {
+	if r, ok := t.r.(*x[K, V]); ok {
+		r.n += delta
+	}
+	for _, q := range path {
+		q.n += delta
+	}
+}
+
 func (t *Tree[K, V]) cat(p *x[K, V], q, r *d[K, V], pi int) {
 	t.ver++
-	q.mvL(r, r.c)
+	t.merges++
+	copy(q.d[q.c:], r.d[:r.c]) // r may be shared, do not use mvL.
+	q.c += r.c
 	if r.n != nil {
 		r.n.p = q
 	} else {
 		t.last = q
 	}
 	q.n = r.n
-	*r = d[K, V]{}
-	t.dPool.Put(r)
+	t.freeD(r)
 	if p.c > 1 {
 		p.extract(pi)
 		p.x[pi].ch = q
 		return
 	}
 
-	switch px := t.r.(type) {
-	case *x[K, V]:
-		*px = x[K, V]{}
-		t.xPool.Put(px)
-	case *d[K, V]:
-		*px = d[K, V]{}
-		t.dPool.Put(px)
-	}
+	t.freeX(p)
 	t.r = q
 }
 
 func (t *Tree[K, V]) catX(p, q, r *x[K, V], pi int) {
 	t.ver++
+	t.merges++
 	q.x[q.c].k = p.x[pi].k
 	copy(q.x[q.c+1:], r.x[:r.c])
 	q.c += r.c + 1
 	q.x[q.c].ch = r.x[r.c].ch
-	*r = x[K, V]{}
-	t.xPool.Put(r)
+	q.n += r.n
+	t.freeX(r)
 	if p.c > 1 {
 		p.c--
 		pc := p.c
@@ -252,69 +457,110 @@ func (t *Tree[K, V]) catX(p, q, r *x[K, V], pi int) {
 		return
 	}
 
-	switch px := t.r.(type) {
-	case *x[K, V]:
-		*px = x[K, V]{}
-		t.xPool.Put(px)
-	case *d[K, V]:
-		*px = d[K, V]{}
-		t.dPool.Put(px)
-	}
+	t.freeX(p)
 	t.r = q
 }
 
 // Delete removes the k's KV pair, if it exists, in which case Delete returns
 // true.
 func (t *Tree[K, V]) Delete(k K) (ok bool) {
+	_, _, ok = t.delete(k)
+	t.augment()
+	return ok
+}
+
+// delete removes the k's KV pair, if it exists, and reports the data page and
+// index the pair was removed from. q is nil if the data page was merged or
+// rebalanced afterwards.
+func (t *Tree[K, V]) delete(k K) (q *d[K, V], i int, ok bool) {
 	pi := -1
 	var p *x[K, V]
-	q := t.r
-	if q == nil {
-		return false
+	var a [maxPath]*x[K, V]
+	path := a[:0]
+	t.mutating()
+	r := t.r
+	if r == nil {
+		return nil, 0, false
 	}
 
+	r = t.own(r)
+	t.r = r
 	for {
-		var i int
-		i, ok = t.find(q, k)
+		i, ok = t.find(r, k)
 		if ok {
-			switch x := q.(type) {
+			switch x := r.(type) {
 			case *x[K, V]:
-				if x.c < kx && q != t.r {
+				if x.c < t.kx && r != t.r {
 					x, i = t.underflowX(p, x, pi, i)
 				}
+				if x != t.r {
+					path = append(path, x)
+				}
 				pi = i + 1
 				p = x
-				q = x.x[pi].ch
+				r = t.ch(x, pi)
 				continue
 			case *d[K, V]:
 				t.extract(x, i)
-				if x.c >= kd {
-					return true
+				t.adjust(path, -1)
+				if x.c >= t.kd {
+					return x, i, true
 				}
 
-				if q != t.r {
+				if r != t.r {
 					t.underflow(p, x, pi)
-				} else if t.c == 0 {
+					return nil, 0, true
+				}
+
+				if t.c == 0 {
 					t.Clear()
+					return nil, 0, true
 				}
-				return true
+
+				return x, i, true
 			}
 		}
 
-		switch x := q.(type) {
+		switch x := r.(type) {
 		case *x[K, V]:
-			if x.c < kx && q != t.r {
+			if x.c < t.kx && r != t.r {
 				x, i = t.underflowX(p, x, pi, i)
 			}
+			if x != t.r {
+				path = append(path, x)
+			}
 			pi = i
 			p = x
-			q = x.x[i].ch
+			r = t.ch(x, i)
 		case *d[K, V]:
-			return false
+			return nil, 0, false
 		}
 	}
 }
 
+// DeleteRange removes all KV pairs having lo <= key < hi and returns their
+// number. The whole data pages in the range are released at once, DeleteRange
+// is O(log n) plus the number of pages released.
+func (t *Tree[K, V]) DeleteRange(lo, hi K) (n int) {
+	t.mutating()
+	if t.r == nil || t.cmp(lo, hi) >= 0 {
+		return 0
+	}
+
+	l, hl, r, hr := t.cut(t.r, t.height(t.r), lo)
+	m, _, r, hr := t.cut(r, hr, hi)
+	if n = t.items(m); n != 0 {
+		t.clr(m)
+	}
+	var sep K
+	if q := t.firstD(r); q != nil {
+		sep = q.d[0].k
+	}
+	r, _ = t.join(l, hl, sep, r, hr)
+	t.setRoot(r)
+	return n
+}
+
 func (t *Tree[K, V]) extract(q *d[K, V], i int) {
 	t.ver++
 	q.c--
@@ -326,6 +572,10 @@ func (t *Tree[K, V]) extract(q *d[K, V], i int) {
 }
 
 func (t *Tree[K, V]) find(q interface{}, k K) (i int, ok bool) {
+	if t.ord != nil {
+		return t.ord(q, k)
+	}
+
 	var mk K
 	l := 0
 	switch x := q.(type) {
@@ -361,6 +611,34 @@ func (t *Tree[K, V]) find(q interface{}, k K) (i int, ok bool) {
 	return l, false
 }
 
+// findOrdered is find with the compare function inlined. It searches for the
+// first key not less than k, which needs only one comparison per step.
+func findOrdered[K stdcmp.Ordered, V interface{}](q interface{}, k K) (i int, ok bool) {
+	switch x := q.(type) {
+	case *x[K, V]:
+		l, h := 0, x.c
+		for l < h {
+			if m := int(uint(l+h) >> 1); stdcmp.Less(x.x[m].k, k) {
+				l = m + 1
+			} else {
+				h = m
+			}
+		}
+		return l, l < x.c && !stdcmp.Less(k, x.x[l].k)
+	case *d[K, V]:
+		l, h := 0, x.c
+		for l < h {
+			if m := int(uint(l+h) >> 1); stdcmp.Less(x.d[m].k, k) {
+				l = m + 1
+			} else {
+				h = m
+			}
+		}
+		return l, l < x.c && !stdcmp.Less(k, x.d[l].k)
+	}
+	return 0, false
+}
+
 // First returns the first item of the tree in the key collating order, or
 // (zero-value, zero-value) if the tree is empty.
 func (t *Tree[K, V]) First() (k K, v V) {
@@ -371,6 +649,16 @@ func (t *Tree[K, V]) First() (k K, v V) {
 	return
 }
 
+// Floor returns the item having the largest key <= k and true, or
+// (zero-value, zero-value, false) if there is no such item.
+func (t *Tree[K, V]) Floor(k K) (K, V, bool) {
+	q, i, ok, l, _ := t.near(k)
+	if !ok {
+		i--
+	}
+	return t.at(q, i, l)
+}
+
 // Get returns the value associated with k and true if it exists. Otherwise Get
 // returns (zero-value, false).
 func (t *Tree[K, V]) Get(k K) (v V, ok bool) {
@@ -399,6 +687,16 @@ func (t *Tree[K, V]) Get(k K) (v V, ok bool) {
 	}
 }
 
+// Higher returns the item having the smallest key > k and true, or
+// (zero-value, zero-value, false) if there is no such item.
+func (t *Tree[K, V]) Higher(k K) (K, V, bool) {
+	q, i, ok, _, r := t.near(k)
+	if ok {
+		i++
+	}
+	return t.at(q, i, r)
+}
+
 func (t *Tree[K, V]) insert(q *d[K, V], i int, k K, v V) *d[K, V] {
 	t.ver++
 	c := q.c
@@ -427,11 +725,74 @@ func (t *Tree[K, V]) Len() int {
 	return t.c
 }
 
+// Lower returns the item having the largest key < k and true, or (zero-value,
+// zero-value, false) if there is no such item.
+func (t *Tree[K, V]) Lower(k K) (K, V, bool) {
+	q, i, _, l, _ := t.near(k)
+	return t.at(q, i-1, l)
+}
+
+// near returns the data page q where k is or would be, the position i of k in
+// q and whether k was found. l and r are the nearest subtrees preceding and
+// following q, if any.
+func (t *Tree[K, V]) near(k K) (q *d[K, V], i int, ok bool, l, r interface{}) {
+	p := t.r
+	for {
+		i, ok = t.find(p, k)
+		switch x := p.(type) {
+		case *x[K, V]:
+			if ok {
+				i++
+			}
+			if i > 0 {
+				l = x.x[i-1].ch
+			}
+			if i < x.c {
+				r = x.x[i+1].ch
+			}
+			p = x.x[i].ch
+		case *d[K, V]:
+			return x, i, ok, l, r
+		default:
+			return nil, 0, false, nil, nil
+		}
+	}
+}
+
+// at returns the item at index i of q. If i is after the end of q, at returns
+// the first item of the subtree s. If i is negative, at returns the last item
+// of s.
+func (t *Tree[K, V]) at(q *d[K, V], i int, s interface{}) (k K, v V, ok bool) {
+	if q, i = t.pos(q, i, s); q == nil {
+		return k, v, false
+	}
+
+	e := &q.d[i]
+	return e.k, e.v, true
+}
+
+// pos returns the data page and the index of the item at returns.
+func (t *Tree[K, V]) pos(q *d[K, V], i int, s interface{}) (*d[K, V], int) {
+	if q != nil {
+		switch {
+		case i >= q.c:
+			q, i = t.firstD(s), 0
+		case i < 0:
+			if q = t.lastD(s); q != nil {
+				i = q.c - 1
+			}
+		}
+	}
+	return q, i
+}
+
 func (t *Tree[K, V]) overflow(p *x[K, V], q *d[K, V], pi, i int, k K, v V) {
 	t.ver++
 	l, r := p.siblings(pi)
-	if l != nil && l.c < 2*kd && i != 0 {
-		s := (2*kd-l.c)/2 + 1 // half plus one
+	if l != nil && l.c < 2*t.kd && i != 0 {
+		t.borrows++
+		l = t.ch(p, pi-1).(*d[K, V])
+		s := (2*t.kd-l.c)/2 + 1 // half plus one
 		if i < s {
 			s = i
 		}
@@ -441,11 +802,13 @@ func (t *Tree[K, V]) overflow(p *x[K, V], q *d[K, V], pi, i int, k K, v V) {
 		return
 	}
 
-	if r != nil && r.c < 2*kd {
-		if i < 2*kd {
-			s := (2*kd-r.c)/2 + 1 // half plus one
-			if 2*kd-i < s {
-				s = 2*kd - i
+	if r != nil && r.c < 2*t.kd {
+		t.borrows++
+		r = t.ch(p, pi+1).(*d[K, V])
+		if i < 2*t.kd {
+			s := (2*t.kd-r.c)/2 + 1 // half plus one
+			if 2*t.kd-i < s {
+				s = 2*t.kd - i
 			}
 			q.mvR(r, s)
 			t.insert(q, i, k, v)
@@ -461,10 +824,79 @@ func (t *Tree[K, V]) overflow(p *x[K, V], q *d[K, V], pi, i int, k K, v V) {
 	t.split(p, q, pi, i, k, v)
 }
 
+// Range returns an iterator over the KV pairs of the tree having lo <= key <
+// hi, in the key collating order. The iteration resumes at the proper key if
+// the tree is mutated in the process, the same way as All does.
+func (t *Tree[K, V]) Range(lo, hi K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		e, _ := t.Seek(lo, UpperBound(hi, false))
+		defer e.Close()
+		for n := 0; ; n++ {
+			k, v, err := e.advance(false, n != 0)
+			if err != nil || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Rank returns the number of keys in the tree that collate before k. Rank is
+// O(log n).
+func (t *Tree[K, V]) Rank(k K) (n int) {
+	q := t.r
+	for {
+		i, ok := t.find(q, k)
+		switch x := q.(type) {
+		case *x[K, V]:
+			if ok {
+				i++
+			}
+			n += x.sum(0, i)
+			q = x.x[i].ch
+		case *d[K, V]:
+			return n + i
+		default:
+			return 0
+		}
+	}
+}
+
+// Bound limits the keys returned by an Enumerator. It is an optional argument
+// of the Seek methods. See LowerBound and UpperBound.
+type Bound[K comparable] struct {
+	incl  bool
+	k     K
+	lower bool
+	set   bool
+}
+
+// LowerBound returns a Bound making the Enumerator return only keys > k, or
+// keys >= k if inclusive is true.
+func LowerBound[K comparable](k K, inclusive bool) Bound[K] {
+	return Bound[K]{incl: inclusive, k: k, lower: true, set: true}
+}
+
+// UpperBound returns a Bound making the Enumerator return only keys < k, or
+// keys <= k if inclusive is true.
+func UpperBound[K comparable](k K, inclusive bool) Bound[K] {
+	return Bound[K]{incl: inclusive, k: k, set: true}
+}
+
 // Seek returns an Enumerator positioned on an item such that k >= item's key.
 // ok reports if k == item.key The Enumerator's position is possibly after the
 // last item in the tree.
-func (t *Tree[K, V]) Seek(k K) (e *Enumerator[K, V], ok bool) {
+//
+// Any keys outside of the optional bounds are not returned by the
+// Enumerator's Next and Prev methods, they return io.EOF instead. Seek does not
+// move the position to within the bounds, use SeekFirst or SeekLast to start
+// the enumeration at a bound.
+func (t *Tree[K, V]) Seek(k K, bounds ...Bound[K]) (e *Enumerator[K, V], ok bool) {
+	e, ok = t.seek(k)
+	e.bound(bounds)
+	return e, ok
+}
+
+func (t *Tree[K, V]) seek(k K) (e *Enumerator[K, V], ok bool) {
 	q := t.r
 	if q == nil {
 		e = t.ePoolGet(nil, false, 0, k, nil)
@@ -494,55 +926,145 @@ func (t *Tree[K, V]) Seek(k K) (e *Enumerator[K, V], ok bool) {
 
 func (t *Tree[K, V]) ePoolGet(err error, hit bool, i int, k K, q *d[K, V]) *Enumerator[K, V] {
 	x := t.ePool.Get().(*Enumerator[K, V])
-	x.err, x.hit, x.i, x.k, x.q, x.t, x.ver = err, hit, i, k, q, t, t.ver
+	x.dir, x.err, x.hit, x.i, x.k, x.q, x.t, x.ver = 0, err, hit, i, k, q, t, t.ver
+	x.bounded, x.hi, x.lo = false, Bound[K]{}, Bound[K]{}
 	return x
 }
 
 // SeekFirst returns an enumerator positioned on the first KV pair in the tree,
 // if any. For an empty tree, err == io.EOF is returned and e will be nil.
-func (t *Tree[K, V]) SeekFirst() (e *Enumerator[K, V], err error) {
-	q := t.first
-	if q == nil {
-		return nil, io.EOF
+//
+// If a lower bound is given, the enumerator is positioned on the first KV
+// pair within the bound. If there is no KV pair within all the bounds, err ==
+// io.EOF is returned and e will be nil. See Seek for the effect of bounds on
+// the enumeration.
+func (t *Tree[K, V]) SeekFirst(bounds ...Bound[K]) (e *Enumerator[K, V], err error) {
+	q, i := t.first, 0
+	for _, b := range bounds {
+		if b.set && b.lower {
+			q, i = t.bounded(b)
+		}
 	}
-
-	return t.ePoolGet(nil, true, 0, q.d[0].k, q), nil
+	return t.seekBounded(q, i, bounds)
 }
 
 // SeekLast returns an enumerator positioned on the last KV pair in the tree,
 // if any. For an empty tree, err == io.EOF is returned and e will be nil.
-func (t *Tree[K, V]) SeekLast() (e *Enumerator[K, V], err error) {
-	q := t.last
+//
+// If an upper bound is given, the enumerator is positioned on the last KV pair
+// within the bound. If there is no KV pair within all the bounds, err ==
+// io.EOF is returned and e will be nil. See Seek for the effect of bounds on
+// the enumeration.
+func (t *Tree[K, V]) SeekLast(bounds ...Bound[K]) (e *Enumerator[K, V], err error) {
+	q, i := t.last, 0
+	if q != nil {
+		i = q.c - 1
+	}
+	for _, b := range bounds {
+		if b.set && !b.lower {
+			q, i = t.bounded(b)
+		}
+	}
+	return t.seekBounded(q, i, bounds)
+}
+
+// bounded returns the position of the first item within a lower bound b or
+// the last item within an upper bound b.
+func (t *Tree[K, V]) bounded(b Bound[K]) (*d[K, V], int) {
+	q, i, ok, l, r := t.near(b.k)
+	switch {
+	case b.lower:
+		if ok && !b.incl {
+			i++
+		}
+		return t.pos(q, i, r)
+	default:
+		if !ok || !b.incl {
+			i--
+		}
+		return t.pos(q, i, l)
+	}
+}
+
+func (t *Tree[K, V]) seekBounded(q *d[K, V], i int, bounds []Bound[K]) (e *Enumerator[K, V], err error) {
 	if q == nil {
 		return nil, io.EOF
 	}
 
-	return t.ePoolGet(nil, true, q.c-1, q.d[q.c-1].k, q), nil
+	e = t.ePoolGet(nil, true, i, q.d[i].k, q)
+	if e.bound(bounds); e.bounded && !e.in(e.k) {
+		e.Close()
+		return nil, io.EOF
+	}
+
+	return e, nil
+}
+
+// Select returns the item having the i-th key of the tree in the key collating
+// order, or (zero-value, zero-value) if i is not in [0, Len()). Select is
+// O(log n).
+func (t *Tree[K, V]) Select(i int) (k K, v V) {
+	if i < 0 || i >= t.c {
+		return
+	}
+
+	q := t.r
+	for {
+		switch x := q.(type) {
+		case *x[K, V]:
+			j := 0
+			for ; j < x.c; j++ {
+				n := x.count(j)
+				if i < n {
+					break
+				}
+
+				i -= n
+			}
+			q = x.x[j].ch
+		case *d[K, V]:
+			e := &x.d[i]
+			return e.k, e.v
+		}
+	}
 }
 
 // Set sets the value associated with k.
 func (t *Tree[K, V]) Set(k K, v V) {
+	t.set(k, v)
+	t.augment()
+}
+
+func (t *Tree[K, V]) set(k K, v V) {
 	pi := -1
 	var p *x[K, V]
+	var a [maxPath]*x[K, V]
+	path := a[:0]
+	t.mutating()
 	q := t.r
 	if q == nil {
-		z := t.insert(t.dPool.Get().(*d[K, V]), 0, k, v)
+		z := t.insert(t.newD(), 0, k, v)
 		t.r, t.first, t.last = z, z, z
 		return
 	}
 
+	q = t.own(q)
+	t.r = q
 	for {
 		i, ok := t.find(q, k)
 		if ok {
 			switch x := q.(type) {
 			case *x[K, V]:
 				i++
-				if x.c > 2*kx {
+				if x.c > 2*t.kx {
 					x, i = t.splitX(p, x, pi, i)
 				}
+				if x != t.r {
+					path = append(path, x)
+				}
 				pi = i
 				p = x
-				q = x.x[i].ch
+				q = t.ch(x, i)
 				continue
 			case *d[K, V]:
 				x.d[i].v = v
@@ -552,19 +1074,23 @@ func (t *Tree[K, V]) Set(k K, v V) {
 
 		switch x := q.(type) {
 		case *x[K, V]:
-			if x.c > 2*kx {
+			if x.c > 2*t.kx {
 				x, i = t.splitX(p, x, pi, i)
 			}
+			if x != t.r {
+				path = append(path, x)
+			}
 			pi = i
 			p = x
-			q = x.x[i].ch
+			q = t.ch(x, i)
 		case *d[K, V]:
 			switch {
-			case x.c < 2*kd:
+			case x.c < 2*t.kd:
 				t.insert(x, i, k, v)
 			default:
 				t.overflow(p, x, pi, i, k, v)
 			}
+			t.adjust(path, 1)
 			return
 		}
 	}
@@ -586,8 +1112,17 @@ type Updater[V interface{}] func(oldV V, exists bool) (newV V, write bool)
 //
 // modulo the differing return values.
 func (t *Tree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
+	oldV, written = t.put(k, upd)
+	t.augment()
+	return oldV, written
+}
+
+func (t *Tree[K, V]) put(k K, upd Updater[V]) (oldV V, written bool) {
 	pi := -1
 	var p *x[K, V]
+	var a [maxPath]*x[K, V]
+	path := a[:0]
+	t.mutating()
 	q := t.r
 	var newV V
 	if q == nil {
@@ -597,23 +1132,28 @@ func (t *Tree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
 			return
 		}
 
-		z := t.insert(t.dPool.Get().(*d[K, V]), 0, k, newV)
+		z := t.insert(t.newD(), 0, k, newV)
 		t.r, t.first, t.last = z, z, z
 		return
 	}
 
+	q = t.own(q)
+	t.r = q
 	for {
 		i, ok := t.find(q, k)
 		if ok {
 			switch x := q.(type) {
 			case *x[K, V]:
 				i++
-				if x.c > 2*kx {
+				if x.c > 2*t.kx {
 					x, i = t.splitX(p, x, pi, i)
 				}
+				if x != t.r {
+					path = append(path, x)
+				}
 				pi = i
 				p = x
-				q = x.x[i].ch
+				q = t.ch(x, i)
 				continue
 			case *d[K, V]:
 				oldV = x.d[i].v
@@ -629,12 +1169,15 @@ func (t *Tree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
 
 		switch x := q.(type) {
 		case *x[K, V]:
-			if x.c > 2*kx {
+			if x.c > 2*t.kx {
 				x, i = t.splitX(p, x, pi, i)
 			}
+			if x != t.r {
+				path = append(path, x)
+			}
 			pi = i
 			p = x
-			q = x.x[i].ch
+			q = t.ch(x, i)
 		case *d[K, V]: // new KV pair
 			newV, written = upd(newV, false)
 			if !written {
@@ -642,11 +1185,12 @@ func (t *Tree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
 			}
 
 			switch {
-			case x.c < 2*kd:
+			case x.c < 2*t.kd:
 				t.insert(x, i, k, newV)
 			default:
 				t.overflow(p, x, pi, i, k, newV)
 			}
+			t.adjust(path, 1)
 			return
 		}
 	}
@@ -654,7 +1198,8 @@ func (t *Tree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
 
 func (t *Tree[K, V]) split(p *x[K, V], q *d[K, V], pi, i int, k K, v V) {
 	t.ver++
-	r := t.dPool.Get().(*d[K, V])
+	t.splits++
+	r := t.newD()
 	if q.n != nil {
 		r.n = q.n
 		r.n.p = r
@@ -664,21 +1209,23 @@ func (t *Tree[K, V]) split(p *x[K, V], q *d[K, V], pi, i int, k K, v V) {
 	q.n = r
 	r.p = q
 
-	copy(r.d[:], q.d[kd:2*kd])
-	for i := range q.d[kd:] {
-		q.d[kd+i] = de[K, V]{}
+	copy(r.d[:], q.d[t.kd:2*t.kd])
+	for i := range q.d[t.kd:] {
+		q.d[t.kd+i] = de[K, V]{}
 	}
-	q.c = kd
-	r.c = kd
+	q.c = t.kd
+	r.c = t.kd
 	var done bool
-	if i > kd {
+	if i > t.kd {
 		done = true
-		t.insert(r, i-kd, k, v)
+		t.insert(r, i-t.kd, k, v)
 	}
 	if pi >= 0 {
 		p.insert(pi, r.d[0].k, r)
 	} else {
-		t.r = t.newX(q).insert(0, r.d[0].k, r)
+		z := t.newX(q).insert(0, r.d[0].k, r)
+		z.n = 2 * t.kd // The new item is accounted for by the caller.
+		t.r = z
 	}
 	if done {
 		return
@@ -689,24 +1236,29 @@ func (t *Tree[K, V]) split(p *x[K, V], q *d[K, V], pi, i int, k K, v V) {
 
 func (t *Tree[K, V]) splitX(p, q *x[K, V], pi int, i int) (*x[K, V], int) {
 	t.ver++
-	r := t.xPool.Get().(*x[K, V])
-	copy(r.x[:], q.x[kx+1:])
-	q.c = kx
-	r.c = kx
+	t.splits++
+	r := t.newX(nil)
+	copy(r.x[:], q.x[t.kx+1:])
+	q.c = t.kx
+	r.c = t.kx
+	r.n = r.sum(0, t.kx+1)
+	q.n -= r.n
 	if pi >= 0 {
-		p.insert(pi, q.x[kx].k, r)
+		p.insert(pi, q.x[t.kx].k, r)
 	} else {
-		t.r = t.newX(q).insert(0, q.x[kx].k, r)
+		z := t.newX(q).insert(0, q.x[t.kx].k, r)
+		z.n = q.n + r.n
+		t.r = z
 	}
 
 	var zk K
-	q.x[kx].k = zk
-	for i := range q.x[kx+1:] {
-		q.x[kx+i+1] = xe[K]{}
+	q.x[t.kx].k = zk
+	for i := range q.x[t.kx+1:] {
+		q.x[t.kx+i+1] = xe[K]{}
 	}
-	if i > kx {
+	if i > t.kx {
 		q = r
-		i -= kx + 1
+		i -= t.kx + 1
 	}
 
 	return q, i
@@ -716,13 +1268,17 @@ func (t *Tree[K, V]) underflow(p *x[K, V], q *d[K, V], pi int) {
 	t.ver++
 	l, r := p.siblings(pi)
 
-	if l != nil && l.c+q.c >= 2*kd {
+	if l != nil && l.c+q.c >= 2*t.kd {
+		t.borrows++
+		l = t.ch(p, pi-1).(*d[K, V])
 		l.mvR(q, 1)
 		p.x[pi-1].k = q.d[0].k
 		return
 	}
 
-	if r != nil && q.c+r.c >= 2*kd {
+	if r != nil && q.c+r.c >= 2*t.kd {
+		t.borrows++
+		r = t.ch(p, pi+1).(*d[K, V])
 		q.mvL(r, 1)
 		p.x[pi].k = r.d[0].k
 		r.d[r.c] = de[K, V]{} // GC
@@ -730,7 +1286,7 @@ func (t *Tree[K, V]) underflow(p *x[K, V], q *d[K, V], pi int) {
 	}
 
 	if l != nil {
-		t.cat(p, l, q, pi-1)
+		t.cat(p, t.ch(p, pi-1).(*d[K, V]), q, pi-1)
 		return
 	}
 
@@ -750,7 +1306,12 @@ func (t *Tree[K, V]) underflowX(p, q *x[K, V], pi int, i int) (*x[K, V], int) {
 		}
 	}
 
-	if l != nil && l.c > kx {
+	if l != nil && l.c > t.kx {
+		t.borrows++
+		l = t.ch(p, pi-1).(*x[K, V])
+		n := l.count(l.c)
+		l.n -= n
+		q.n += n
 		q.x[q.c+1].ch = q.x[q.c].ch
 		copy(q.x[1:], q.x[:q.c])
 		q.x[0].ch = l.x[l.c].ch
@@ -762,7 +1323,12 @@ func (t *Tree[K, V]) underflowX(p, q *x[K, V], pi int, i int) (*x[K, V], int) {
 		return q, i
 	}
 
-	if r != nil && r.c > kx {
+	if r != nil && r.c > t.kx {
+		t.borrows++
+		r = t.ch(p, pi+1).(*x[K, V])
+		n := r.count(0)
+		r.n -= n
+		q.n += n
 		q.x[q.c].k = p.x[pi].k
 		q.c++
 		q.x[q.c].ch = r.x[0].ch
@@ -778,6 +1344,7 @@ func (t *Tree[K, V]) underflowX(p, q *x[K, V], pi int, i int) (*x[K, V], int) {
 	}
 
 	if l != nil {
+		l = t.ch(p, pi-1).(*x[K, V])
 		i += l.c + 1
 		t.catX(p, l, q, pi-1)
 		q = l
@@ -798,6 +1365,88 @@ func (e *Enumerator[K, V]) Close() {
 	t.ePool.Put(e)
 }
 
+func (e *Enumerator[K, V]) bound(bounds []Bound[K]) {
+	for _, b := range bounds {
+		switch {
+		case !b.set:
+			// nop
+		case b.lower:
+			e.lo, e.bounded = b, true
+		default:
+			e.hi, e.bounded = b, true
+		}
+	}
+}
+
+// in reports whether k is within the bounds of e.
+func (e *Enumerator[K, V]) in(k K) bool {
+	if e.hi.set {
+		if c := e.t.cmp(k, e.hi.k); c > 0 || c == 0 && !e.hi.incl {
+			return false
+		}
+	}
+	if e.lo.set {
+		if c := e.t.cmp(k, e.lo.k); c < 0 || c == 0 && !e.lo.incl {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete removes the item last returned by Next or Prev, if it still exists,
+// in which case Delete returns true. The enumeration continues with the item
+// that would follow the deleted one. Unless the deletion rebalances the data
+// page, e stays positioned and does not have to resync on its next move.
+func (e *Enumerator[K, V]) Delete() bool {
+	if e.dir == 0 {
+		return false
+	}
+
+	synced := e.ver == e.t.ver && e.rq != nil
+	e.rq = nil
+	q, i, ok := e.t.delete(e.k)
+	e.t.augment()
+	if !ok || !synced || q == nil || e.q != nil && e.q.gen != e.t.gen {
+		return ok
+	}
+
+	if e.q == q && e.i > i {
+		e.i--
+	}
+	e.ver = e.t.ver
+	return true
+}
+
+// SetValue sets the value of the item last returned by Next or Prev, if it
+// still exists, in which case SetValue returns true. Unless the tree is
+// augmented or the data page of the item is shared with a snapshot, the value
+// is updated in place. That does not affect any enumerators of the tree.
+func (e *Enumerator[K, V]) SetValue(v V) bool {
+	if e.dir == 0 {
+		return false
+	}
+
+	if q := e.rq; q != nil && e.ver == e.t.ver && q.gen == e.t.gen && e.t.aug == nil {
+		q.d[e.ri].v = v
+		return true
+	}
+
+	synced := e.ver == e.t.ver
+	_, ok := e.t.Put(e.k, func(_ V, exists bool) (V, bool) { return v, exists })
+	if ok && synced {
+		// Put copied the shared pages. Keep e past the item, the same
+		// way as if the value was updated in place.
+		e.resync()
+		switch {
+		case e.dir > 0:
+			e.next()
+		default:
+			e.prev()
+		}
+	}
+	return ok
+}
+
 // Next returns the currently enumerated item, if it exists and moves to the
 // next item in the key collation order. If there is no item to return, err ==
 // io.EOF is returned.
@@ -807,9 +1456,7 @@ func (e *Enumerator[K, V]) Next() (k K, v V, err error) {
 	}
 
 	if e.ver != e.t.ver {
-		f, _ := e.t.Seek(e.k)
-		*e = *f
-		f.Close()
+		e.resync()
 	}
 	if e.q == nil {
 		e.err, err = io.EOF, io.EOF
@@ -823,8 +1470,19 @@ func (e *Enumerator[K, V]) Next() (k K, v V, err error) {
 	}
 
 	i := e.q.d[e.i]
+	if e.bounded && !e.in(i.k) {
+		e.err, err = io.EOF, io.EOF
+		return
+	}
+
 	k, v = i.k, i.v
-	e.k, e.hit = k, true
+	e.dir, e.k, e.hit, e.rq, e.ri = 1, k, true, e.q, e.i
+	if e.i < e.q.c-1 {
+		// Within the page, avoid the call to next, it is not inlined.
+		e.i++
+		return
+	}
+
 	e.next()
 	return
 }
@@ -839,7 +1497,11 @@ func (e *Enumerator[K, V]) next() error {
 	case e.i < e.q.c-1:
 		e.i++
 	default:
-		if e.q, e.i = e.q.n, 0; e.q == nil {
+		q := e.q.n
+		if e.t.ro {
+			q = e.t.nextD(e.q)
+		}
+		if e.q, e.i = q, 0; e.q == nil {
 			e.err = io.EOF
 		}
 	}
@@ -855,9 +1517,7 @@ func (e *Enumerator[K, V]) Prev() (k K, v V, err error) {
 	}
 
 	if e.ver != e.t.ver {
-		f, _ := e.t.Seek(e.k)
-		*e = *f
-		f.Close()
+		e.resync()
 	}
 	if e.q == nil {
 		e.err, err = io.EOF, io.EOF
@@ -878,12 +1538,62 @@ func (e *Enumerator[K, V]) Prev() (k K, v V, err error) {
 	}
 
 	i := e.q.d[e.i]
+	if e.bounded && !e.in(i.k) {
+		e.err, err = io.EOF, io.EOF
+		return
+	}
+
 	k, v = i.k, i.v
-	e.k, e.hit = k, true
+	e.dir, e.k, e.hit, e.rq, e.ri = -1, k, true, e.q, e.i
+	if e.i > 0 {
+		e.i--
+		return
+	}
+
 	e.prev()
 	return
 }
 
+// resync repositions e at e.k after the tree was mutated. The bounds of e and
+// the direction of its last move are kept.
+func (e *Enumerator[K, V]) resync() {
+	bounded, dir, hi, lo := e.bounded, e.dir, e.hi, e.lo
+	f, _ := e.t.seek(e.k)
+	*e = *f
+	f.Close()
+	e.bounded, e.dir, e.hi, e.lo = bounded, dir, hi, lo
+}
+
+// advance returns the item returned by Prev if backward is set or by Next
+// otherwise. If skip is set and the tree was mutated since the last move of
+// e, the resynced enumerator returns the last returned key again if it still
+// exists. advance then skips it, so the iterators never yield a key twice.
+func (e *Enumerator[K, V]) advance(backward, skip bool) (k K, v V, err error) {
+	if skip && e.ver != e.t.ver {
+		return e.resume(backward)
+	}
+
+	if backward {
+		return e.Prev()
+	}
+
+	return e.Next()
+}
+
+func (e *Enumerator[K, V]) resume(backward bool) (k K, v V, err error) {
+	last := e.k
+	for {
+		if backward {
+			k, v, err = e.Prev()
+		} else {
+			k, v, err = e.Next()
+		}
+		if err != nil || e.t.cmp(k, last) != 0 {
+			return k, v, err
+		}
+	}
+}
+
 func (e *Enumerator[K, V]) prev() error {
 	if e.q == nil {
 		e.err = io.EOF
@@ -894,7 +1604,11 @@ func (e *Enumerator[K, V]) prev() error {
 	case e.i > 0:
 		e.i--
 	default:
-		if e.q = e.q.p; e.q == nil {
+		q := e.q.p
+		if e.t.ro {
+			q = e.t.prevD(e.q)
+		}
+		if e.q = q; e.q == nil {
 			e.err = io.EOF
 			break
 		}
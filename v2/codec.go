@@ -0,0 +1,357 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// The encoding of a tree is
+//
+//	magic    "\x00BPT"
+//	version  uvarint
+//	blocks   one per data page
+//	end      uvarint 0
+//	count    uvarint, the number of KV pairs
+//
+// A block is
+//
+//	n        uvarint > 0, the number of KV pairs
+//	size     uvarint, the size of payload
+//	payload  n times key and value as encoded by the codecs
+//	crc      uint32, little endian, CRC-32C of payload
+
+const (
+	codecMagic   = "\x00BPT"
+	codecVersion = 1
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Codec encodes and decodes the keys or the values of a tree. See Tree.Encode
+// and TreeDecode.
+type Codec[T interface{}] interface {
+	// Append appends the encoding of v to b and returns the extended
+	// buffer.
+	Append(b []byte, v T) ([]byte, error)
+
+	// Decode decodes a value from the start of b. It returns the value and
+	// the number of bytes consumed.
+	Decode(b []byte) (v T, n int, err error)
+}
+
+// VarintCodec is a Codec of signed integers encoded as varints.
+type VarintCodec[T ~int | ~int8 | ~int16 | ~int32 | ~int64] struct{}
+
+// Append implements Codec.
+func (VarintCodec[T]) Append(b []byte, v T) ([]byte, error) {
+	return binary.AppendVarint(b, int64(v)), nil
+}
+
+// Decode implements Codec.
+func (VarintCodec[T]) Decode(b []byte) (v T, n int, err error) {
+	x, n := binary.Varint(b)
+	if n <= 0 || int64(T(x)) != x {
+		return v, 0, fmt.Errorf("VarintCodec: invalid varint")
+	}
+
+	return T(x), n, nil
+}
+
+// UvarintCodec is a Codec of unsigned integers encoded as uvarints.
+type UvarintCodec[T ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr] struct{}
+
+// Append implements Codec.
+func (UvarintCodec[T]) Append(b []byte, v T) ([]byte, error) {
+	return binary.AppendUvarint(b, uint64(v)), nil
+}
+
+// Decode implements Codec.
+func (UvarintCodec[T]) Decode(b []byte) (v T, n int, err error) {
+	x, n := binary.Uvarint(b)
+	if n <= 0 || uint64(T(x)) != x {
+		return v, 0, fmt.Errorf("UvarintCodec: invalid uvarint")
+	}
+
+	return T(x), n, nil
+}
+
+// StringCodec is a Codec of strings encoded as their length followed by their
+// bytes.
+type StringCodec[T ~string] struct{}
+
+// Append implements Codec.
+func (StringCodec[T]) Append(b []byte, v T) ([]byte, error) {
+	return append(binary.AppendUvarint(b, uint64(len(v))), v...), nil
+}
+
+// Decode implements Codec.
+func (StringCodec[T]) Decode(b []byte) (v T, n int, err error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || l > uint64(len(b)-n) {
+		return v, 0, fmt.Errorf("StringCodec: invalid string")
+	}
+
+	return T(b[n : n+int(l)]), n + int(l), nil
+}
+
+// BinaryCodec is a Codec of the values implementing
+// encoding.BinaryMarshaler whose pointers implement
+// encoding.BinaryUnmarshaler. The values are encoded as their length followed
+// by their binary form.
+type BinaryCodec[T encoding.BinaryMarshaler, P interface {
+	*T
+	encoding.BinaryUnmarshaler
+}] struct{}
+
+// Append implements Codec.
+func (BinaryCodec[T, P]) Append(b []byte, v T) ([]byte, error) {
+	m, err := v.MarshalBinary()
+	if err != nil {
+		return b, err
+	}
+
+	return append(binary.AppendUvarint(b, uint64(len(m))), m...), nil
+}
+
+// Decode implements Codec.
+func (BinaryCodec[T, P]) Decode(b []byte) (v T, n int, err error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || l > uint64(len(b)-n) {
+		return v, 0, fmt.Errorf("BinaryCodec: invalid length")
+	}
+
+	if err = P(&v).UnmarshalBinary(b[n : n+int(l)]); err != nil {
+		return v, 0, err
+	}
+
+	return v, n + int(l), nil
+}
+
+// Encode writes the KV pairs of t to w, encoding the keys with kc and the
+// values with vc. The encoding has a version header and every block of KV
+// pairs is protected by a checksum. Use TreeDecode to rebuild the tree.
+func (t *Tree[K, V]) Encode(w io.Writer, kc Codec[K], vc Codec[V]) (err error) {
+	b := binary.AppendUvarint([]byte(codecMagic), codecVersion)
+	var p []byte
+	for q := t.first; q != nil; q = t.nextD(q) {
+		p = p[:0]
+		for i := 0; i < q.c; i++ {
+			e := &q.d[i]
+			if p, err = kc.Append(p, e.k); err != nil {
+				return err
+			}
+
+			if p, err = vc.Append(p, e.v); err != nil {
+				return err
+			}
+		}
+		b = binary.AppendUvarint(b, uint64(q.c))
+		b = binary.AppendUvarint(b, uint64(len(p)))
+		b = append(b, p...)
+		b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(p, crcTable))
+		if _, err = w.Write(b); err != nil {
+			return err
+		}
+
+		b = b[:0]
+	}
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendUvarint(b, uint64(t.c))
+	_, err = w.Write(b)
+	return err
+}
+
+// TreeDecode returns a newly created Tree having the KV pairs written by
+// Tree.Encode to r, decoding the keys with kc and the values with vc. The
+// keys must be in strictly increasing order according to cmp. The tree is
+// built bottom-up the same way as by TreeFromSorted.
+//
+// If r is not an io.ByteReader, it is buffered and TreeDecode may read past
+// the end of the encoded tree.
+func TreeDecode[K comparable, V interface{}](r io.Reader, cmp Cmp[K], kc Codec[K], vc Codec[V]) (*Tree[K, V], error) {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	t := TreeNew[K, V](cmp)
+	if err := t.decode(br, kc, vc); err != nil {
+		return nil, fmt.Errorf("TreeDecode: %w", err)
+	}
+
+	return t, nil
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// decode loads the KV pairs written by Tree.Encode to r into the empty tree t.
+// On error t is left empty.
+func (t *Tree[K, V]) decode(r byteReader, kc Codec[K], vc Codec[V]) (err error) {
+	var hdr [len(codecMagic)]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return fmt.Errorf("reading header: %w", eof(err))
+	}
+
+	if string(hdr[:]) != codecMagic {
+		return fmt.Errorf("invalid header")
+	}
+
+	switch v, err := binary.ReadUvarint(r); {
+	case err != nil:
+		return fmt.Errorf("reading version: %w", eof(err))
+	case v != codecVersion:
+		return fmt.Errorf("unsupported version %d", v)
+	}
+
+	l := newLoader(t, 1)
+	defer func() {
+		if err != nil {
+			t.r = l.finish()
+			t.Clear()
+		}
+	}()
+
+	var last K
+	var p []byte
+	for blk := 0; ; blk++ {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("block #%d: %w", blk, eof(err))
+		}
+
+		if n == 0 {
+			break
+		}
+
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("block #%d: %w", blk, eof(err))
+		}
+
+		if size > math.MaxInt32 {
+			return fmt.Errorf("block #%d: invalid size", blk)
+		}
+
+		// The buffer grows only as the data arrives, a corrupted size
+		// cannot allocate more memory than the input holds.
+		w := bytes.NewBuffer(p[:0])
+		if _, err = io.CopyN(w, r, int64(size)+4); err != nil {
+			return fmt.Errorf("block #%d: %w", blk, eof(err))
+		}
+
+		p = w.Bytes()
+
+		if crc32.Checksum(p[:size], crcTable) != binary.LittleEndian.Uint32(p[size:]) {
+			return fmt.Errorf("block #%d: checksum mismatch", blk)
+		}
+
+		b := p[:size]
+		for ; n != 0; n-- {
+			k, m, err := kc.Decode(b)
+			if err != nil {
+				return fmt.Errorf("item #%d: key: %w", t.c, err)
+			}
+
+			b = b[m:]
+			v, m, err := vc.Decode(b)
+			if err != nil {
+				return fmt.Errorf("item #%d: value: %w", t.c, err)
+			}
+
+			b = b[m:]
+			if t.c != 0 && t.cmp(last, k) >= 0 {
+				return fmt.Errorf("item #%d: key out of order", t.c)
+			}
+
+			last = k
+			l.add(k, v)
+		}
+		if len(b) != 0 {
+			return fmt.Errorf("block #%d: invalid size", blk)
+		}
+	}
+	switch n, err := binary.ReadUvarint(r); {
+	case err != nil:
+		return fmt.Errorf("reading count: %w", eof(err))
+	case n != uint64(t.c):
+		return fmt.Errorf("got %d items, expected %d", t.c, n)
+	}
+
+	t.setRoot(l.finish())
+	return nil
+}
+
+// TreeMarshaler implements encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler for a Tree, encoding its keys and values by the
+// codecs passed to TreeMarshalerNew. MarshalBinary reads the tree,
+// UnmarshalBinary mutates it, see the concurrency considerations in the
+// package documentation.
+type TreeMarshaler[K comparable, V interface{}] struct {
+	kc Codec[K]
+	t  *Tree[K, V]
+	vc Codec[V]
+}
+
+// TreeMarshalerNew returns a TreeMarshaler of t using kc and vc for encoding
+// and decoding the keys and the values. t must be a tree created by one of
+// the constructors of this package.
+func TreeMarshalerNew[K comparable, V interface{}](t *Tree[K, V], kc Codec[K], vc Codec[V]) *TreeMarshaler[K, V] {
+	return &TreeMarshaler[K, V]{kc: kc, t: t, vc: vc}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. It returns the KV pairs
+// of the tree encoded by Tree.Encode.
+func (m *TreeMarshaler[K, V]) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	if err := m.t.Encode(&b, m.kc, m.vc); err != nil {
+		return nil, fmt.Errorf("MarshalBinary: %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It replaces the KV
+// pairs of the tree with the ones encoded in data by MarshalBinary or
+// Tree.Encode. The page sizes of the tree are kept. If data is not valid, the
+// tree is not changed.
+func (m *TreeMarshaler[K, V]) UnmarshalBinary(data []byte) error {
+	t := m.t
+	t.mutating()
+	r := bytes.NewReader(data)
+	z := t.empty()
+	if err := z.decode(r, m.kc, m.vc); err != nil {
+		return fmt.Errorf("UnmarshalBinary: %w", err)
+	}
+
+	if r.Len() != 0 {
+		z.Clear()
+		return fmt.Errorf("UnmarshalBinary: %d bytes of trailing data", r.Len())
+	}
+
+	t.Clear()
+	t.c, t.first, t.gen, t.last, t.r = z.c, z.first, z.gen, z.last, z.r
+	t.ver++
+	return nil
+}
+
+// eof reports a premature end of the input as io.ErrUnexpectedEOF.
+func eof(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,345 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// ConcurrentTree is a B+tree safe for concurrent use by multiple goroutines
+// without a global lock. Readers do not lock at all, writers lock only the
+// pages they modify.
+//
+// Every page has a versioned latch and its content, which is never modified
+// in place. A writer locks the page, publishes a modified copy of the content
+// and unlocks the page, which increments its version. A reader records the
+// version of a page before reading its content and validates the version of
+// the page, and of its parent, before relying on what it read. On a conflict
+// the operation restarts from the root. Full index pages are split on the way
+// down, the same way as Tree.Set does, so an insert never propagates a split
+// upwards and locks at most a page and its parent.
+//
+// Because the content of a page is never modified in place, every Set, Put
+// or Delete allocates a copy of the whole content of a data page, 2*kd items,
+// which costs more than a mutation of a Tree. On a single CPU a SyncTree is
+// faster, see BenchmarkParallel for comparing the two.
+//
+// Delete does not merge underfilled pages, nor does it free empty ones. A
+// tree shrunk by deletions keeps all its pages, its depth never decreases.
+type ConcurrentTree[K comparable, V interface{}] struct {
+	latch // guards r
+	c     atomic.Int64
+	cmp   Cmp[K]
+	r     atomic.Pointer[cnode[K, V]]
+}
+
+// latch is a version lock. The lowest bit of the version is set while the
+// latch is locked, unlocking increments the version.
+type latch struct {
+	v atomic.Uint64
+}
+
+// rlock returns the current version of l and whether it is not locked.
+func (l *latch) rlock() (uint64, bool) {
+	v := l.v.Load()
+	return v, v&1 == 0
+}
+
+// valid reports whether the version of l is still v.
+func (l *latch) valid(v uint64) bool { return l.v.Load() == v }
+
+// lock locks l if its version is still v.
+func (l *latch) lock(v uint64) bool { return l.v.CompareAndSwap(v, v+1) }
+
+// unlock unlocks l, setting a new version.
+func (l *latch) unlock() { l.v.Add(1) }
+
+// abort unlocks l locked at version v without modifying it.
+func (l *latch) abort(v uint64) { l.v.Store(v) }
+
+type cnode[K comparable, V interface{}] struct { // page
+	latch
+	d atomic.Pointer[cd[K, V]] // nil in index pages
+	x atomic.Pointer[cx[K, V]] // nil in data pages
+}
+
+type cd[K comparable, V interface{}] struct { // data page content
+	c int
+	d [2 * kd]de[K, V]
+}
+
+type cx[K comparable, V interface{}] struct { // index page content
+	c  int
+	ch [2*kx + 2]*cnode[K, V]
+	k  [2*kx + 1]K
+}
+
+// ConcurrentTreeNew returns a newly created, empty ConcurrentTree. The compare
+// function is used for key collation.
+func ConcurrentTreeNew[K comparable, V interface{}](cmp Cmp[K]) *ConcurrentTree[K, V] {
+	t := &ConcurrentTree[K, V]{cmp: cmp}
+	r := &cnode[K, V]{}
+	r.d.Store(&cd[K, V]{})
+	t.r.Store(r)
+	return t
+}
+
+func (t *ConcurrentTree[K, V]) findD(q *cd[K, V], k K) (i int, ok bool) {
+	l, h := 0, q.c-1
+	for l <= h {
+		m := (l + h) >> 1
+		switch cmp := t.cmp(k, q.d[m].k); {
+		case cmp > 0:
+			l = m + 1
+		case cmp == 0:
+			return m, true
+		default:
+			h = m - 1
+		}
+	}
+	return l, false
+}
+
+// findX returns the index of the child of q covering k.
+func (t *ConcurrentTree[K, V]) findX(q *cx[K, V], k K) int {
+	l, h := 0, q.c-1
+	for l <= h {
+		m := (l + h) >> 1
+		switch cmp := t.cmp(k, q.k[m]); {
+		case cmp > 0:
+			l = m + 1
+		case cmp == 0:
+			return m + 1
+		default:
+			h = m - 1
+		}
+	}
+	return l
+}
+
+// Delete removes the k's KV pair, if it exists, in which case Delete returns
+// true.
+func (t *ConcurrentTree[K, V]) Delete(k K) (ok bool) {
+	for {
+		n, v, q := t.leaf(k, nil)
+		if q == nil {
+			continue
+		}
+
+		i, ok := t.findD(q, k)
+		if !ok {
+			if n.valid(v) {
+				return false
+			}
+
+			continue
+		}
+
+		z := &cd[K, V]{c: q.c - 1}
+		if !n.lock(v) {
+			continue
+		}
+
+		copy(z.d[:], q.d[:i])
+		copy(z.d[i:], q.d[i+1:q.c])
+		n.d.Store(z)
+		n.unlock()
+		t.c.Add(-1)
+		return true
+	}
+}
+
+// Get returns the value associated with k and true if it exists. Otherwise Get
+// returns (zero-value, false).
+func (t *ConcurrentTree[K, V]) Get(k K) (v V, ok bool) {
+	for {
+		n, nv, q := t.leaf(k, nil)
+		if q == nil {
+			continue
+		}
+
+		i, ok := t.findD(q, k)
+		if !n.valid(nv) {
+			continue
+		}
+
+		if ok {
+			return q.d[i].v, true
+		}
+
+		return v, false
+	}
+}
+
+// Len returns the number of items in the tree.
+func (t *ConcurrentTree[K, V]) Len() int {
+	return int(t.c.Load())
+}
+
+// leaf returns the data page n covering k, its version v and its content q.
+// If full is not nil, full index pages are split on the way down and full(q)
+// reports whether a data page has to be split as well. leaf returns a nil q
+// if the operation has to be restarted.
+func (t *ConcurrentTree[K, V]) leaf(k K, full func(q *cd[K, V]) bool) (n *cnode[K, V], v uint64, q *cd[K, V]) {
+	tv, ok := t.rlock()
+	if !ok {
+		runtime.Gosched()
+		return nil, 0, nil
+	}
+
+	n = t.r.Load()
+	var p *cnode[K, V] // nil: n is the root
+	pv := tv
+	for {
+		if v, ok = n.rlock(); !ok || !t.parent(p).valid(pv) {
+			runtime.Gosched()
+			return nil, 0, nil
+		}
+
+		x := n.x.Load()
+		if x == nil {
+			q = n.d.Load()
+			if full != nil && full(q) {
+				t.split(p, pv, n, v)
+				return nil, 0, nil
+			}
+
+			return n, v, q
+		}
+
+		if full != nil && x.c == 2*kx+1 {
+			t.split(p, pv, n, v)
+			return nil, 0, nil
+		}
+
+		p, pv = n, v
+		n = x.ch[t.findX(x, k)]
+	}
+}
+
+// Put combines Get and Set in a more efficient way where the tree is walked
+// only once. See Tree.Put. upd is called with no page locked. If the data page
+// of k changes before the new value is stored, the operation is restarted and
+// upd is called again, so upd must be prepared to be called more than once.
+// upd must not call the methods of t.
+func (t *ConcurrentTree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
+	var i int
+	var ok bool
+	full := func(q *cd[K, V]) bool {
+		i, ok = t.findD(q, k)
+		return !ok && q.c == 2*kd
+	}
+	z := &cd[K, V]{} // Allocated before locking to keep the lock short.
+	for {
+		n, v, q := t.leaf(k, full)
+		if q == nil {
+			continue
+		}
+
+		var old V
+		if ok {
+			old = q.d[i].v
+		}
+		newV, w := upd(old, ok)
+		if !w {
+			if !n.valid(v) {
+				continue
+			}
+
+			return old, false
+		}
+
+		// Locking at version v validates that q is still the content
+		// of n, which upd has seen.
+		if !n.lock(v) {
+			continue
+		}
+
+		*z = *q
+		switch {
+		case ok:
+			z.d[i].v = newV
+		default:
+			copy(z.d[i+1:], q.d[i:q.c])
+			z.d[i] = de[K, V]{k: k, v: newV}
+			z.c++
+			t.c.Add(1)
+		}
+		n.d.Store(z)
+		n.unlock()
+		return old, true
+	}
+}
+
+// Set sets the value associated with k.
+func (t *ConcurrentTree[K, V]) Set(k K, v V) {
+	t.Put(k, func(V, bool) (V, bool) { return v, true })
+}
+
+// parent returns the latch guarding the pointer to the child of p. For the
+// root page, p is nil and the latch is the one of t.
+func (t *ConcurrentTree[K, V]) parent(p *cnode[K, V]) *latch {
+	if p == nil {
+		return &t.latch
+	}
+
+	return &p.latch
+}
+
+// split splits the full page n at version v. p is its parent at version pv.
+// Nothing is done if any of the pages changed in the meantime.
+func (t *ConcurrentTree[K, V]) split(p *cnode[K, V], pv uint64, n *cnode[K, V], v uint64) {
+	pl := t.parent(p)
+	if !pl.lock(pv) {
+		return
+	}
+
+	if !n.lock(v) {
+		pl.abort(pv)
+		return
+	}
+
+	r := &cnode[K, V]{}
+	var sep K
+	switch q := n.d.Load(); {
+	case q != nil:
+		l, z := &cd[K, V]{c: kd}, &cd[K, V]{c: kd}
+		copy(l.d[:], q.d[:kd])
+		copy(z.d[:], q.d[kd:])
+		sep = z.d[0].k
+		r.d.Store(z)
+		n.d.Store(l)
+	default:
+		q := n.x.Load()
+		l, z := &cx[K, V]{c: kx}, &cx[K, V]{c: kx}
+		copy(l.k[:], q.k[:kx])
+		copy(l.ch[:], q.ch[:kx+1])
+		sep = q.k[kx]
+		copy(z.k[:], q.k[kx+1:])
+		copy(z.ch[:], q.ch[kx+1:])
+		r.x.Store(z)
+		n.x.Store(l)
+	}
+
+	switch {
+	case p == nil:
+		z := &cnode[K, V]{}
+		z.x.Store(&cx[K, V]{c: 1, ch: [2*kx + 2]*cnode[K, V]{n, r}, k: [2*kx + 1]K{sep}})
+		t.r.Store(z)
+	default:
+		q := p.x.Load()
+		i := t.findX(q, sep)
+		z := *q
+		copy(z.k[i+1:], q.k[i:q.c])
+		copy(z.ch[i+2:], q.ch[i+1:q.c+1])
+		z.k[i] = sep
+		z.ch[i+1] = r
+		z.c++
+		p.x.Store(&z)
+	}
+	n.unlock()
+	pl.unlock()
+}
@@ -0,0 +1,389 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+// Subtrees are handled as (root page, height) pairs, data pages have height
+// zero. An empty subtree has a nil root. Only the root page of a subtree may
+// be underfilled, ie. have less than kd items in a data page or less than kx-1
+// separators in an index page.
+
+func (t *Tree[K, V]) items(q interface{}) int {
+	switch x := q.(type) {
+	case *x[K, V]:
+		return x.n
+	case *d[K, V]:
+		return x.c
+	}
+	return 0
+}
+
+func (t *Tree[K, V]) height(q interface{}) (h int) {
+	for {
+		x, ok := q.(*x[K, V])
+		if !ok {
+			return h
+		}
+
+		q = x.x[0].ch
+		h++
+	}
+}
+
+func (t *Tree[K, V]) firstD(q interface{}) *d[K, V] {
+	for {
+		switch x := q.(type) {
+		case *x[K, V]:
+			q = x.x[0].ch
+		case *d[K, V]:
+			return x
+		default:
+			return nil
+		}
+	}
+}
+
+func (t *Tree[K, V]) lastD(q interface{}) *d[K, V] {
+	for {
+		switch x := q.(type) {
+		case *x[K, V]:
+			q = x.x[x.c].ch
+		case *d[K, V]:
+			return x
+		default:
+			return nil
+		}
+	}
+}
+
+// setRoot makes q the root of t and recomputes all the other fields derived
+// from the root.
+func (t *Tree[K, V]) setRoot(q interface{}) {
+	t.ver++
+	t.r = q
+	t.c = t.items(q)
+	t.first, t.last = t.firstD(q), t.lastD(q)
+	if t.first != nil {
+		t.first.p = nil
+		t.last.n = nil
+	}
+	t.augment()
+}
+
+// freeX recycles q unless it is shared.
+func (t *Tree[K, V]) freeX(q *x[K, V]) {
+	if q.gen == t.gen {
+		clear(q.x)
+		*q = x[K, V]{x: q.x}
+		t.xPool.Put(q)
+	}
+}
+
+// freeD recycles q unless it is shared.
+func (t *Tree[K, V]) freeD(q *d[K, V]) {
+	if q.gen == t.gen {
+		clear(q.d)
+		*q = d[K, V]{d: q.d}
+		t.dPool.Put(q)
+	}
+}
+
+// moveXL moves the first m children of r to the end of l. sep separates l and
+// r. The new separator of l and r is returned.
+func (t *Tree[K, V]) moveXL(l *x[K, V], sep K, r *x[K, V], m int) K {
+	n := r.sum(0, m)
+	l.x[l.c].k = sep
+	copy(l.x[l.c+1:], r.x[:m])
+	l.c += m
+	sep = l.x[l.c].k
+	var zk K
+	l.x[l.c].k = zk
+	copy(r.x[:], r.x[m:r.c+1])
+	for i := r.c - m + 1; i <= r.c; i++ {
+		r.x[i] = xe[K]{} // GC
+	}
+	r.c -= m
+	r.x[r.c].k = zk
+	l.n += n
+	r.n -= n
+	return sep
+}
+
+// moveXR moves the last m children of l to the start of r. sep separates l
+// and r. The new separator of l and r is returned.
+func (t *Tree[K, V]) moveXR(l *x[K, V], sep K, r *x[K, V], m int) K {
+	n := l.sum(l.c-m+1, l.c+1)
+	copy(r.x[m:], r.x[:r.c+1])
+	copy(r.x[:m], l.x[l.c-m+1:l.c+1])
+	r.x[m-1].k = sep
+	r.c += m
+	l.c -= m
+	sep = l.x[l.c].k
+	var zk K
+	l.x[l.c].k = zk
+	for i := l.c + 1; i <= l.c+m; i++ {
+		l.x[i] = xe[K]{} // GC
+	}
+	l.n -= n
+	r.n += n
+	return sep
+}
+
+// balance fixes the fill of the adjacent sibling pages l and r, separated by
+// sep, of which any can be underfilled. Both pages must belong to the current
+// generation of t. If the items of both pages fit into l,
+// r is merged into l and balance returns a nil page. Otherwise balance
+// returns the, possibly new, separator and r.
+func (t *Tree[K, V]) balance(l interface{}, sep K, r interface{}) (K, interface{}) {
+	switch l := l.(type) {
+	case *x[K, V]:
+		r := r.(*x[K, V])
+		switch {
+		case l.c >= t.kx-1 && r.c >= t.kx-1:
+			// ok
+		case l.c+r.c+1 <= 2*t.kx+1:
+			t.merges++
+			l.x[l.c].k = sep
+			copy(l.x[l.c+1:], r.x[:r.c+1])
+			l.c += r.c + 1
+			l.n += r.n
+			t.freeX(r)
+			return sep, nil
+		case l.c < r.c:
+			t.borrows++
+			sep = t.moveXL(l, sep, r, (r.c-l.c)/2)
+		default:
+			t.borrows++
+			sep = t.moveXR(l, sep, r, (l.c-r.c)/2)
+		}
+		return sep, r
+	case *d[K, V]:
+		r := r.(*d[K, V])
+		switch {
+		case l.c >= t.kd && r.c >= t.kd:
+			// ok
+		case l.c+r.c <= 2*t.kd:
+			t.merges++
+			l.mvL(r, r.c)
+			if l.n = r.n; l.n != nil {
+				l.n.p = l
+			}
+			t.freeD(r)
+			return sep, nil
+		case l.c < r.c:
+			t.borrows++
+			l.mvL(r, (r.c-l.c)/2)
+		default:
+			t.borrows++
+			l.mvR(r, (l.c-r.c)/2)
+		}
+		return r.d[0].k, r
+	}
+	panic("internal error")
+}
+
+// insertX inserts child ch and its preceding separator sep at index i of q,
+// splitting q if it is full. If a split happened, insertX returns the new
+// right sibling of q and its separator. The items of ch must be already
+// accounted for in q.n.
+func (t *Tree[K, V]) insertX(q *x[K, V], i int, sep K, ch interface{}) (K, *x[K, V]) {
+	if q.c < 2*t.kx+1 {
+		q.insert(i, sep, ch)
+		return sep, nil
+	}
+
+	t.splits++
+	r := t.newX(nil)
+	copy(r.x[:], q.x[t.kx+1:q.c+1])
+	r.c = q.c - t.kx - 1
+	up := q.x[t.kx].k
+	q.c = t.kx
+	var zk K
+	q.x[t.kx].k = zk
+	for j := t.kx + 1; j < len(q.x); j++ {
+		q.x[j] = xe[K]{} // GC
+	}
+	switch {
+	case i <= t.kx:
+		q.insert(i, sep, ch)
+	default:
+		r.insert(i-t.kx-1, sep, ch)
+	}
+	r.n = r.sum(0, r.c+1)
+	q.n -= r.n
+	return up, r
+}
+
+// joinR grafts the subtree r of height hr as the rightmost subtree of q of
+// height hq > hr. q must belong to the current generation of t. sep separates
+// the items of q and r. If q was split, joinR returns the new right sibling of
+// q and its separator.
+func (t *Tree[K, V]) joinR(q *x[K, V], hq int, sep K, r interface{}, hr int) (K, *x[K, V]) {
+	q.n += t.items(r)
+	var z interface{}
+	switch {
+	case hq == hr+1:
+		sep, z = t.balance(t.ch(q, q.c), sep, t.own(r))
+	default:
+		var zx *x[K, V]
+		if sep, zx = t.joinR(t.ch(q, q.c).(*x[K, V]), hq-1, sep, r, hr); zx != nil {
+			z = zx
+		}
+	}
+	if z == nil {
+		return sep, nil
+	}
+
+	return t.insertX(q, q.c, sep, z)
+}
+
+// joinL grafts the subtree l of height hl as the leftmost subtree of q of
+// height hq > hl. q must belong to the current generation of t. sep separates
+// the items of l and q. If q was split, joinL returns the new right sibling of
+// q and its separator.
+func (t *Tree[K, V]) joinL(q *x[K, V], hq int, sep K, l interface{}, hl int) (K, *x[K, V]) {
+	q.n += t.items(l)
+	var z interface{}
+	switch {
+	case hq == hl+1:
+		l = t.own(l)
+		sep, z = t.balance(l, sep, t.ch(q, 0))
+		q.x[0].ch = l
+	default:
+		var zx *x[K, V]
+		if sep, zx = t.joinL(t.ch(q, 0).(*x[K, V]), hq-1, sep, l, hl); zx != nil {
+			z = zx
+		}
+	}
+	if z == nil {
+		return sep, nil
+	}
+
+	return t.insertX(q, 0, sep, z)
+}
+
+// join returns the subtree having all the items of the subtrees l and r, of
+// heights hl and hr. All keys of l must collate before sep and all keys of r
+// must not collate before sep.
+func (t *Tree[K, V]) join(l interface{}, hl int, sep K, r interface{}, hr int) (interface{}, int) {
+	switch {
+	case l == nil:
+		return r, hr
+	case r == nil:
+		return l, hl
+	}
+
+	ld, rd := t.lastD(l), t.firstD(r)
+	ld.n, rd.p = rd, ld
+	switch {
+	case hl > hr:
+		l = t.own(l)
+		if sep, z := t.joinR(l.(*x[K, V]), hl, sep, r, hr); z != nil {
+			return t.newRoot(l, sep, z), hl + 1
+		}
+
+		return l, hl
+	case hl < hr:
+		r = t.own(r)
+		if sep, z := t.joinL(r.(*x[K, V]), hr, sep, l, hl); z != nil {
+			return t.newRoot(r, sep, z), hr + 1
+		}
+
+		return r, hr
+	default:
+		l, r = t.own(l), t.own(r)
+		if sep, z := t.balance(l, sep, r); z != nil {
+			return t.newRoot(l, sep, z), hl + 1
+		}
+
+		return l, hl
+	}
+}
+
+func (t *Tree[K, V]) newRoot(l interface{}, sep K, r interface{}) *x[K, V] {
+	z := t.newX(l).insert(0, sep, r)
+	z.n = z.sum(0, 2)
+	return z
+}
+
+// cut splits the subtree q of height h into the subtrees having the keys
+// collating before k and the rest. The data pages of both resulting subtrees
+// remain linked together.
+func (t *Tree[K, V]) cut(q interface{}, h int, k K) (l interface{}, hl int, r interface{}, hr int) {
+	switch q := q.(type) {
+	case *x[K, V]:
+		i, ok := t.find(q, k)
+		if ok {
+			i++
+		}
+		ch := q.x[i].ch
+		var sl, sr K
+		if i > 0 {
+			sl = q.x[i-1].k
+		}
+		if i < q.c {
+			sr = q.x[i].k
+		}
+
+		var fl, fr interface{}
+		hfl, hfr := h, h
+		switch n := q.c - i; n {
+		case 0:
+			// nop
+		case 1:
+			fr, hfr = q.x[q.c].ch, h-1
+		default:
+			z := t.newX(nil)
+			copy(z.x[:], q.x[i+1:q.c+1])
+			z.c = n - 1
+			z.n = z.sum(0, n)
+			fr = z
+		}
+		switch i {
+		case 0:
+			t.freeX(q)
+		case 1:
+			fl, hfl = q.x[0].ch, h-1
+			t.freeX(q)
+		default:
+			q = t.own(q).(*x[K, V])
+			var zk K
+			q.x[i-1].k = zk
+			for j := i; j <= q.c; j++ {
+				q.x[j] = xe[K]{} // GC
+			}
+			q.c = i - 1
+			q.n = q.sum(0, i)
+			fl = q
+		}
+
+		cl, hcl, cr, hcr := t.cut(ch, h-1, k)
+		l, hl = t.join(fl, hfl, sl, cl, hcl)
+		r, hr = t.join(cr, hcr, sr, fr, hfr)
+		return l, hl, r, hr
+	case *d[K, V]:
+		i, _ := t.find(q, k)
+		switch i {
+		case 0:
+			return nil, 0, q, 0
+		case q.c:
+			return q, 0, nil, 0
+		}
+
+		q = t.own(q).(*d[K, V])
+		z := t.newD()
+		copy(z.d[:], q.d[i:q.c])
+		for j := i; j < q.c; j++ {
+			q.d[j] = de[K, V]{} // GC
+		}
+		z.c = q.c - i
+		q.c = i
+		if z.n = q.n; z.n != nil {
+			z.n.p = z
+		}
+		q.n, z.p = z, q
+		return q, 0, z, 0
+	}
+	return nil, 0, nil, 0
+}
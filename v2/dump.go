@@ -0,0 +1,239 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DumpOptions amend the output of Tree.Dump and Tree.WriteDot. A nil
+// *DumpOptions dumps the whole tree, formatting the keys and values using
+// fmt.Sprint.
+type DumpOptions[K comparable, V interface{}] struct {
+	// Key and Value format the keys and the values. If nil, fmt.Sprint is
+	// used.
+	Key   func(k K) string
+	Value func(v V) string
+
+	// Path selects the root page of the dumped subtree by the child indices
+	// leading to it from the root of the tree, the same way as the page
+	// paths reported by Verify. An empty path selects the whole tree.
+	Path []int
+
+	// Depth is the number of page levels dumped, starting at the root page
+	// of the subtree. Zero means all levels.
+	Depth int
+}
+
+// dumper holds the state of Tree.Dump and Tree.WriteDot.
+type dumper[K comparable, V interface{}] struct {
+	err error
+	ids map[interface{}]int
+	o   DumpOptions[K, V]
+	w   io.Writer
+}
+
+func (t *Tree[K, V]) dumper(w io.Writer, o *DumpOptions[K, V]) (*dumper[K, V], interface{}, error) {
+	dp := &dumper[K, V]{ids: map[interface{}]int{}, w: w}
+	if o != nil {
+		dp.o = *o
+	}
+	if dp.o.Key == nil {
+		dp.o.Key = func(k K) string { return fmt.Sprint(k) }
+	}
+	if dp.o.Value == nil {
+		dp.o.Value = func(v V) string { return fmt.Sprint(v) }
+	}
+	q := t.r
+	for _, i := range dp.o.Path {
+		x, ok := q.(*x[K, V])
+		if !ok || i < 0 || i > x.c {
+			return nil, nil, fmt.Errorf("invalid path %v", dp.o.Path)
+		}
+
+		q = x.x[i].ch
+	}
+	return dp, q, nil
+}
+
+func (dp *dumper[K, V]) printf(format string, args ...interface{}) {
+	if dp.err == nil {
+		_, dp.err = fmt.Fprintf(dp.w, format, args...)
+	}
+}
+
+// id returns the number of page q, numbering the pages in the order they are
+// first referred to.
+func (dp *dumper[K, V]) id(q interface{}) int {
+	if n, ok := dp.ids[q]; ok {
+		return n
+	}
+
+	n := len(dp.ids) + 1
+	dp.ids[q] = n
+	return n
+}
+
+// idD returns the number of the data page q or zero if q is nil.
+func (dp *dumper[K, V]) idD(q *d[K, V]) int {
+	if q == nil {
+		return 0
+	}
+
+	return dp.id(q)
+}
+
+// node returns the Graphviz node name of page q.
+func (dp *dumper[K, V]) node(q interface{}) string {
+	if _, ok := q.(*d[K, V]); ok {
+		return fmt.Sprintf("D%d", dp.id(q))
+	}
+
+	return fmt.Sprintf("X%d", dp.id(q))
+}
+
+// more reports whether the children of a page at level are dumped.
+func (dp *dumper[K, V]) more(level int) bool {
+	return dp.o.Depth <= 0 || level+1 < dp.o.Depth
+}
+
+// Dump writes a text representation of t to w, one page per line. The pages
+// are numbered in the order they are first referred to, index pages are
+// written as
+//
+//	X#id c <number of keys> n <number of items> {C#<child 0> <key 0> C#<child 1> ... C#<child c>}
+//
+// and data pages as
+//
+//	D#id P#<previous> N#<next> c <number of items> {<key 0>:<value 0> ...}
+//
+// Every page is indented by ". " for each level below the root of the dumped
+// subtree. The previous and next data page links are omitted in snapshots.
+// See DumpOptions for formatting the keys and values and for limiting the
+// output. o may be nil.
+func (t *Tree[K, V]) Dump(w io.Writer, o *DumpOptions[K, V]) error {
+	dp, q, err := t.dumper(w, o)
+	if err != nil {
+		return fmt.Errorf("Dump: %w", err)
+	}
+
+	var page func(q interface{}, level int)
+	page = func(q interface{}, level int) {
+		pref := strings.Repeat(". ", level)
+		switch x := q.(type) {
+		case *x[K, V]:
+			dp.printf("%sX#%d c %d n %d {", pref, dp.id(x), x.c, x.n)
+			for i := 0; i <= x.c; i++ {
+				if i != 0 {
+					dp.printf(" %s ", dp.o.Key(x.x[i-1].k))
+				}
+				dp.printf("C#%d", dp.id(x.x[i].ch))
+			}
+			dp.printf("}\n")
+			if !dp.more(level) {
+				return
+			}
+
+			for i := 0; i <= x.c; i++ {
+				page(x.x[i].ch, level+1)
+			}
+		case *d[K, V]:
+			dp.printf("%sD#%d", pref, dp.id(x))
+			if !t.ro {
+				dp.printf(" P#%d N#%d", dp.idD(x.p), dp.idD(x.n))
+			}
+			dp.printf(" c %d {", x.c)
+			for i := 0; i < x.c; i++ {
+				if i != 0 {
+					dp.printf(" ")
+				}
+				dp.printf("%s:%s", dp.o.Key(x.d[i].k), dp.o.Value(x.d[i].v))
+			}
+			dp.printf("}\n")
+		}
+	}
+	page(q, 0)
+	return dp.err
+}
+
+// WriteDot writes t to w as a Graphviz digraph. Index pages are drawn as
+// records of their child pointers and keys, data pages as records of their
+// items. The data page links are drawn as dashed edges, except in snapshots.
+// The children of the pages at the depth limit are drawn as "..." nodes. See
+// DumpOptions for formatting the keys and values and for limiting the output.
+// o may be nil.
+func (t *Tree[K, V]) WriteDot(w io.Writer, o *DumpOptions[K, V]) error {
+	dp, q, err := t.dumper(w, o)
+	if err != nil {
+		return fmt.Errorf("WriteDot: %w", err)
+	}
+
+	dp.printf("digraph btree {\n\tnode [shape=record];\n")
+	var links []*d[K, V]
+	var page func(q interface{}, level int)
+	page = func(q interface{}, level int) {
+		switch x := q.(type) {
+		case *x[K, V]:
+			id := dp.id(x)
+			dp.printf("\tX%d [label=\"", id)
+			for i := 0; i <= x.c; i++ {
+				if i != 0 {
+					dp.printf("|%s|", dotEscape(dp.o.Key(x.x[i-1].k)))
+				}
+				dp.printf("<c%d>", i)
+			}
+			dp.printf("\"];\n")
+			for i := 0; i <= x.c; i++ {
+				ch := x.x[i].ch
+				if !dp.more(level) {
+					dp.printf("\tT%d_%d [label=\"...\", shape=plaintext];\n\tX%d:c%d -> T%d_%d;\n", id, i, id, i, id, i)
+					continue
+				}
+
+				dp.printf("\tX%d:c%d -> %s;\n", id, i, dp.node(ch))
+				page(ch, level+1)
+			}
+		case *d[K, V]:
+			dp.printf("\tD%d [label=\"", dp.id(x))
+			for i := 0; i < x.c; i++ {
+				if i != 0 {
+					dp.printf("|")
+				}
+				dp.printf("%s: %s", dotEscape(dp.o.Key(x.d[i].k)), dotEscape(dp.o.Value(x.d[i].v)))
+			}
+			dp.printf("\"];\n")
+			links = append(links, x)
+		}
+	}
+	page(q, 0)
+	if !t.ro {
+		for i := 1; i < len(links); i++ {
+			if l, r := links[i-1], links[i]; l.n == r {
+				dp.printf("\tD%d -> D%d [style=dashed, constraint=false];\n", dp.id(l), dp.id(r))
+			}
+		}
+	}
+	dp.printf("}\n")
+	return dp.err
+}
+
+// dotEscape escapes the characters having a special meaning in the labels of
+// Graphviz record nodes.
+func dotEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '"', '\\', '{', '}', '|', '<', '>':
+			b.WriteByte('\\')
+		case '\n':
+			b.WriteString(`\n`)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
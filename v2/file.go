@@ -0,0 +1,1197 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// The file of a FileTree is a sequence of fixed-size pages. Page 0 holds two
+// copies of the header, at offsets 0 and 256. The valid copy having the
+// greater sequence number is the current one, Commit writes the other one.
+//
+//	0	magic "\x00BPF"
+//	4	version, uint32
+//	8	page size, uint32
+//	16	root page, uint64, zero if the tree is empty
+//	24	number of KV pairs, uint64
+//	32	number of pages of the tree, uint64
+//	40	first free page, uint64, zero if none
+//	48	sequence number, uint64
+//	56	first page of the journal, uint64, zero if none
+//	64	number of pages in the journal, uint64
+//	72	CRC-32C of the journal directory, uint32
+//	76	CRC-32C of bytes [0, 76), uint32
+//
+// All the other pages start with
+//
+//	0	page type, byte
+//	4	CRC-32C of bytes [8, page size), uint32
+//	8	number of keys, uint32
+//
+// followed, in data pages, by
+//
+//	16	previous data page, uint64
+//	24	next data page, uint64
+//	32	n times key and value, each prefixed by its uvarint encoded length
+//
+// in index pages by
+//
+//	16	child 0, uint64
+//	24	n times key, prefixed by its uvarint encoded length, and child
+//		i+1, uint64
+//
+// and in free pages by
+//
+//	16	next free page, uint64
+//
+// The journal follows the pages of the tree. It starts with the directory,
+// the numbers of the journaled pages, uint64 each, padded to whole pages. The
+// new contents of the journaled pages follow in the same order.
+//
+// All integers are little endian. The pages are encoded by the Codecs of the
+// tree and they are split when their encoding does not fit into a page.
+
+const (
+	fileMagic   = "\x00BPF"
+	fileVersion = 1
+
+	fileHdrSize  = 80
+	fileHdrSlot  = 256 // offset of the second copy of the header
+	dataHdrSize  = 32
+	indexHdrSize = 24
+
+	pageData  = 1
+	pageIndex = 2
+	pageFree  = 3
+
+	// DefaultPageSize is the page size of a FileTree used if
+	// FileOptions.PageSize is zero.
+	DefaultPageSize = 4096
+
+	// DefaultCacheSize is the number of cached pages of a FileTree used if
+	// FileOptions.CacheSize is zero.
+	DefaultCacheSize = 1024
+)
+
+// File is the storage of a FileTree. *os.File implements File.
+type File interface {
+	io.Closer
+	io.ReaderAt
+	io.WriterAt
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FileOptions amend the behavior of FileTreeOpen.
+type FileOptions struct {
+	// PageSize is the size of the pages of a new file. It must be a power
+	// of two >= 512. The page size of an existing file is never changed.
+	PageSize int
+
+	// CacheSize is the number of pages cached in memory. The pages
+	// modified and not committed yet are kept in memory in addition to
+	// CacheSize.
+	CacheSize int
+}
+
+// FileTree is a B+tree stored in a File. Its pages are read as needed and
+// cached in memory. The changes made by Set and Delete are kept in memory
+// until Commit writes them to the file. The changes not committed are lost in
+// a crash, a FileTree does not use a write-ahead log like LogTree does. A
+// FileTree is not safe for concurrent use.
+//
+// The keys and values are stored as encoded by the Codecs of the tree. An
+// encoded KV pair can take at most a quarter of a page.
+//
+// Delete does not merge underfilled pages, there is no compaction either. A
+// page becoming empty is returned to the list of free pages of the file and
+// reused later, the file never shrinks.
+type FileTree[K comparable, V interface{}] struct {
+	buf       []byte
+	cache     map[uint64]*fpage[K, V]
+	cacheSize int
+	cmp       Cmp[K]
+	count     uint64
+	f         File
+	free      uint64
+	item      []byte // encoding buffer of Set
+	kc        Codec[K]
+	lru       list.List // clean pages, most recently used first
+	pageSize  int
+	pages     uint64
+	root      uint64
+	seq       uint64 // sequence number of the current header
+	vc        Codec[V]
+	ver       int64
+}
+
+// fpage is a decoded page of a FileTree.
+type fpage[K comparable, V interface{}] struct {
+	ch    []uint64 // index page children
+	dirty bool
+	e     *list.Element // in FileTree.lru, nil if dirty
+	ks    []K
+	ksz   []int // encoded sizes of ks
+	n     uint64
+	next  uint64 // data page chain or the free list
+	prev  uint64
+	size  int // encoded size
+	typ   byte
+	vs    []V
+	vsz   []int // encoded sizes of vs
+}
+
+// FileTreeOpen returns a FileTree stored in f. If f is empty, a new empty
+// tree is created in f. The compare function is used for key collation, kc
+// and vc encode the keys and values. The tree must be always opened with the
+// same compare function and codecs. o may be nil.
+func FileTreeOpen[K comparable, V interface{}](f File, cmp Cmp[K], kc Codec[K], vc Codec[V], o *FileOptions) (*FileTree[K, V], error) {
+	if o == nil {
+		o = &FileOptions{}
+	}
+	t := &FileTree[K, V]{
+		cache:     map[uint64]*fpage[K, V]{},
+		cacheSize: o.CacheSize,
+		cmp:       cmp,
+		f:         f,
+		kc:        kc,
+		pageSize:  o.PageSize,
+		vc:        vc,
+	}
+	if t.cacheSize <= 0 {
+		t.cacheSize = DefaultCacheSize
+	}
+	if t.pageSize == 0 {
+		t.pageSize = DefaultPageSize
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() == 0 {
+		if t.pageSize < 512 || t.pageSize > 1<<24 || t.pageSize&(t.pageSize-1) != 0 {
+			return nil, fmt.Errorf("FileTreeOpen: invalid page size %d", t.pageSize)
+		}
+
+		t.buf = make([]byte, t.pageSize)
+		t.pages = 1
+		if err := t.writeHeader(0, 0, 0); err != nil {
+			return nil, err
+		}
+
+		return t, f.Sync()
+	}
+
+	if err := t.readHeader(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *FileTree[K, V]) readHeader() error {
+	var b [fileHdrSlot + fileHdrSize]byte
+	if _, err := t.f.ReadAt(b[:], 0); err != nil {
+		return fmt.Errorf("FileTreeOpen: reading header: %w", eof(err))
+	}
+
+	var h []byte
+	var err error
+	for _, c := range [][]byte{b[:fileHdrSize], b[fileHdrSlot:]} {
+		switch e := checkHeader(c); {
+		case e != nil:
+			if err == nil {
+				err = e
+			}
+		case h == nil || binary.LittleEndian.Uint64(c[48:]) > binary.LittleEndian.Uint64(h[48:]):
+			h = c
+		}
+	}
+	if h == nil {
+		return fmt.Errorf("FileTreeOpen: %w", err)
+	}
+
+	t.pageSize = int(binary.LittleEndian.Uint32(h[8:]))
+	t.root = binary.LittleEndian.Uint64(h[16:])
+	t.count = binary.LittleEndian.Uint64(h[24:])
+	t.pages = binary.LittleEndian.Uint64(h[32:])
+	t.free = binary.LittleEndian.Uint64(h[40:])
+	t.seq = binary.LittleEndian.Uint64(h[48:])
+	t.buf = make([]byte, t.pageSize)
+	if jp := binary.LittleEndian.Uint64(h[56:]); jp != 0 {
+		return t.replay(jp, binary.LittleEndian.Uint64(h[64:]), binary.LittleEndian.Uint32(h[72:]))
+	}
+
+	return nil
+}
+
+// checkHeader reports whether b is a valid copy of the header.
+func checkHeader(b []byte) error {
+	if string(b[:4]) != fileMagic {
+		return fmt.Errorf("invalid header")
+	}
+
+	if crc32.Checksum(b[:76], crcTable) != binary.LittleEndian.Uint32(b[76:]) {
+		return fmt.Errorf("header checksum mismatch")
+	}
+
+	if v := binary.LittleEndian.Uint32(b[4:]); v != fileVersion {
+		return fmt.Errorf("unsupported version %d", v)
+	}
+
+	return nil
+}
+
+// writeHeader writes the next header to the copy not holding the current one.
+// jp, jn and jcrc describe the journal, if any.
+func (t *FileTree[K, V]) writeHeader(jp, jn uint64, jcrc uint32) error {
+	t.seq++
+	_, err := t.f.WriteAt(t.header(jp, jn, jcrc), int64(t.seq&1)*fileHdrSlot)
+	return err
+}
+
+// header returns the encoding of the header. The result is valid until the
+// next use of t.buf.
+func (t *FileTree[K, V]) header(jp, jn uint64, jcrc uint32) []byte {
+	b := t.buf[:fileHdrSize]
+	clear(b)
+	copy(b, fileMagic)
+	binary.LittleEndian.PutUint32(b[4:], fileVersion)
+	binary.LittleEndian.PutUint32(b[8:], uint32(t.pageSize))
+	binary.LittleEndian.PutUint64(b[16:], t.root)
+	binary.LittleEndian.PutUint64(b[24:], t.count)
+	binary.LittleEndian.PutUint64(b[32:], t.pages)
+	binary.LittleEndian.PutUint64(b[40:], t.free)
+	binary.LittleEndian.PutUint64(b[48:], t.seq)
+	binary.LittleEndian.PutUint64(b[56:], jp)
+	binary.LittleEndian.PutUint64(b[64:], jn)
+	binary.LittleEndian.PutUint32(b[72:], jcrc)
+	binary.LittleEndian.PutUint32(b[76:], crc32.Checksum(b[:76], crcTable))
+	return b
+}
+
+// Close closes the file of the tree. Changes not committed are discarded.
+func (t *FileTree[K, V]) Close() error {
+	err := t.f.Close()
+	*t = FileTree[K, V]{}
+	return err
+}
+
+// Commit writes all changes to the file and syncs it. No page of the tree is
+// overwritten until the changes are durable. They are written to a journal
+// after the pages of the tree first and the header committing them is written
+// after a sync. Only then the pages are updated from memory and the journal is
+// dropped. If the process crashes at any point, the file keeps the state of
+// either the previous Commit or this one, FileTreeOpen replays the journal of
+// a committed but unfinished Commit.
+func (t *FileTree[K, V]) Commit() error {
+	var a []*fpage[K, V]
+	for _, p := range t.cache {
+		if p.dirty {
+			a = append(a, p)
+		}
+	}
+	if len(a) == 0 {
+		if err := t.writeHeader(0, 0, 0); err != nil {
+			return err
+		}
+
+		return t.f.Sync()
+	}
+
+	sort.Slice(a, func(i, j int) bool { return a[i].n < a[j].n })
+	dir := make([]byte, t.journalDir(uint64(len(a))))
+	for i, p := range a {
+		binary.LittleEndian.PutUint64(dir[8*i:], p.n)
+	}
+	jp := t.pages
+	if _, err := t.f.WriteAt(dir, int64(jp)*int64(t.pageSize)); err != nil {
+		return err
+	}
+
+	n := jp + uint64(len(dir)/t.pageSize)
+	for i, p := range a {
+		if err := t.write(p, n+uint64(i)); err != nil {
+			return err
+		}
+	}
+	if err := t.f.Sync(); err != nil {
+		return err
+	}
+
+	if err := t.writeHeader(jp, uint64(len(a)), crc32.Checksum(dir, crcTable)); err != nil {
+		return err
+	}
+
+	if err := t.f.Sync(); err != nil {
+		return err
+	}
+
+	for _, p := range a {
+		if err := t.write(p, p.n); err != nil {
+			return err
+		}
+
+		p.dirty = false
+		p.e = t.lru.PushFront(p)
+	}
+	return t.dropJournal()
+}
+
+// journalDir returns the size of the directory of a journal of n pages.
+func (t *FileTree[K, V]) journalDir(n uint64) int {
+	return int((8*n + uint64(t.pageSize) - 1) / uint64(t.pageSize) * uint64(t.pageSize))
+}
+
+// replay copies the jn pages of the journal starting at page jp to their
+// places. jcrc is the checksum of the journal directory.
+func (t *FileTree[K, V]) replay(jp, jn uint64, jcrc uint32) error {
+	if jp < t.pages || jn == 0 || jn >= t.pages {
+		return fmt.Errorf("FileTreeOpen: invalid journal")
+	}
+
+	ps := int64(t.pageSize)
+	dir := make([]byte, t.journalDir(jn))
+	if _, err := t.f.ReadAt(dir, int64(jp)*ps); err != nil {
+		return fmt.Errorf("FileTreeOpen: reading journal: %w", eof(err))
+	}
+
+	if crc32.Checksum(dir, crcTable) != jcrc {
+		return fmt.Errorf("FileTreeOpen: journal checksum mismatch")
+	}
+
+	b, n := t.buf, int64(jp)+int64(len(dir))/ps
+	for i := int64(0); i < int64(jn); i++ {
+		pn := binary.LittleEndian.Uint64(dir[8*i:])
+		if pn == 0 || pn >= t.pages {
+			return fmt.Errorf("FileTreeOpen: invalid journal")
+		}
+
+		if _, err := t.f.ReadAt(b, (n+i)*ps); err != nil {
+			return fmt.Errorf("FileTreeOpen: reading journal: %w", eof(err))
+		}
+
+		if crc32.Checksum(b[8:], crcTable) != binary.LittleEndian.Uint32(b[4:]) {
+			return fmt.Errorf("FileTreeOpen: journal page %d: checksum mismatch", i)
+		}
+
+		if _, err := t.f.WriteAt(b, int64(pn)*ps); err != nil {
+			return err
+		}
+	}
+	return t.dropJournal()
+}
+
+// dropJournal syncs the pages copied from the journal to their places, writes
+// a header without the journal and truncates the file to the pages of the
+// tree.
+func (t *FileTree[K, V]) dropJournal() error {
+	if err := t.f.Sync(); err != nil {
+		return err
+	}
+
+	if err := t.writeHeader(0, 0, 0); err != nil {
+		return err
+	}
+
+	if err := t.f.Sync(); err != nil {
+		return err
+	}
+
+	return t.f.Truncate(int64(t.pages) * int64(t.pageSize))
+}
+
+// write writes p to page n of the file.
+func (t *FileTree[K, V]) write(p *fpage[K, V], n uint64) error {
+	b, err := t.encode(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.f.WriteAt(b, int64(n)*int64(t.pageSize))
+	return err
+}
+
+// encode returns the encoding of p. The result is valid until the next use of
+// t.buf.
+func (t *FileTree[K, V]) encode(p *fpage[K, V]) (b []byte, err error) {
+	b = t.buf
+	clear(b)
+	b[0] = p.typ
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(p.ks)))
+	switch p.typ {
+	case pageData:
+		binary.LittleEndian.PutUint64(b[16:], p.prev)
+		binary.LittleEndian.PutUint64(b[24:], p.next)
+		b = b[:dataHdrSize]
+		for i, k := range p.ks {
+			n := len(b)
+			b = binary.AppendUvarint(b, uint64(p.ksz[i]))
+			if b, err = t.kc.Append(b, k); err != nil {
+				return nil, err
+			}
+
+			b = binary.AppendUvarint(b, uint64(p.vsz[i]))
+			if b, err = t.vc.Append(b, p.vs[i]); err != nil {
+				return nil, err
+			}
+
+			if len(b)-n != itemSize(p.ksz[i])+itemSize(p.vsz[i]) {
+				return nil, fmt.Errorf("FileTree: codec is not deterministic")
+			}
+		}
+	case pageIndex:
+		binary.LittleEndian.PutUint64(b[16:], p.ch[0])
+		b = b[:indexHdrSize]
+		for i, k := range p.ks {
+			n := len(b)
+			b = binary.AppendUvarint(b, uint64(p.ksz[i]))
+			if b, err = t.kc.Append(b, k); err != nil {
+				return nil, err
+			}
+
+			if len(b)-n != itemSize(p.ksz[i]) {
+				return nil, fmt.Errorf("FileTree: codec is not deterministic")
+			}
+
+			b = binary.LittleEndian.AppendUint64(b, p.ch[i+1])
+		}
+	case pageFree:
+		binary.LittleEndian.PutUint64(b[16:], p.next)
+	}
+	b = t.buf
+	binary.LittleEndian.PutUint32(b[4:], crc32.Checksum(b[8:], crcTable))
+	return b, nil
+}
+
+// page returns page n.
+func (t *FileTree[K, V]) page(n uint64) (*fpage[K, V], error) {
+	if p := t.cache[n]; p != nil {
+		if p.e != nil {
+			t.lru.MoveToFront(p.e)
+		}
+		return p, nil
+	}
+
+	if n == 0 || n >= t.pages {
+		return nil, fmt.Errorf("FileTree: invalid page number %d", n)
+	}
+
+	b := t.buf
+	if _, err := t.f.ReadAt(b, int64(n)*int64(t.pageSize)); err != nil {
+		return nil, fmt.Errorf("FileTree: reading page %d: %w", n, eof(err))
+	}
+
+	p, err := t.decode(n, b)
+	if err != nil {
+		return nil, fmt.Errorf("FileTree: page %d: %w", n, err)
+	}
+
+	p.e = t.lru.PushFront(p)
+	t.cache[n] = p
+	return p, nil
+}
+
+func (t *FileTree[K, V]) decode(n uint64, b []byte) (p *fpage[K, V], err error) {
+	if crc32.Checksum(b[8:], crcTable) != binary.LittleEndian.Uint32(b[4:]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+
+	p = &fpage[K, V]{n: n, typ: b[0]}
+	c := int(binary.LittleEndian.Uint32(b[8:]))
+	if c > t.pageSize {
+		return nil, fmt.Errorf("invalid number of keys")
+	}
+
+	off := 0
+	item := func() ([]byte, error) {
+		l, m := binary.Uvarint(b[off:])
+		if m <= 0 || l > uint64(len(b)-off-m) {
+			return nil, fmt.Errorf("invalid item length")
+		}
+
+		off += m
+		r := b[off : off+int(l)]
+		off += int(l)
+		return r, nil
+	}
+	switch p.typ {
+	case pageData:
+		p.prev = binary.LittleEndian.Uint64(b[16:])
+		p.next = binary.LittleEndian.Uint64(b[24:])
+		p.size, off = dataHdrSize, dataHdrSize
+		for i := 0; i < c; i++ {
+			kb, err := item()
+			if err != nil {
+				return nil, err
+			}
+
+			vb, err := item()
+			if err != nil {
+				return nil, err
+			}
+
+			k, _, err := t.kc.Decode(kb)
+			if err != nil {
+				return nil, err
+			}
+
+			v, _, err := t.vc.Decode(vb)
+			if err != nil {
+				return nil, err
+			}
+
+			p.ks, p.ksz = append(p.ks, k), append(p.ksz, len(kb))
+			p.vs, p.vsz = append(p.vs, v), append(p.vsz, len(vb))
+			p.size += itemSize(len(kb)) + itemSize(len(vb))
+		}
+	case pageIndex:
+		p.ch = append(p.ch, binary.LittleEndian.Uint64(b[16:]))
+		p.size, off = indexHdrSize, indexHdrSize
+		for i := 0; i < c; i++ {
+			kb, err := item()
+			if err != nil {
+				return nil, err
+			}
+
+			if off+8 > len(b) {
+				return nil, fmt.Errorf("invalid index page")
+			}
+
+			k, _, err := t.kc.Decode(kb)
+			if err != nil {
+				return nil, err
+			}
+
+			p.ks, p.ksz = append(p.ks, k), append(p.ksz, len(kb))
+			p.ch = append(p.ch, binary.LittleEndian.Uint64(b[off:]))
+			off += 8
+			p.size += itemSize(len(kb)) + 8
+		}
+	case pageFree:
+		p.next = binary.LittleEndian.Uint64(b[16:])
+	default:
+		return nil, fmt.Errorf("invalid page type %d", p.typ)
+	}
+	return p, nil
+}
+
+// itemSize returns the encoded size of a key or value of size n.
+func itemSize(n int) int {
+	var b [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(b[:], uint64(n)) + n
+}
+
+// trim evicts the least recently used clean pages exceeding the cache size.
+// It is called only at the start of the methods, so the pages in use are
+// never evicted.
+func (t *FileTree[K, V]) trim() {
+	for t.lru.Len() > t.cacheSize {
+		p := t.lru.Remove(t.lru.Back()).(*fpage[K, V])
+		delete(t.cache, p.n)
+	}
+}
+
+// modify marks p as modified.
+func (t *FileTree[K, V]) modify(p *fpage[K, V]) {
+	if !p.dirty {
+		p.dirty = true
+		t.lru.Remove(p.e)
+		p.e = nil
+	}
+}
+
+// alloc returns a new page of type typ.
+func (t *FileTree[K, V]) alloc(typ byte) (*fpage[K, V], error) {
+	var n uint64
+	switch {
+	case t.free != 0:
+		p, err := t.page(t.free)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.typ != pageFree {
+			return nil, fmt.Errorf("FileTree: page %d is not free", p.n)
+		}
+
+		if p.e != nil {
+			t.lru.Remove(p.e)
+		}
+		n, t.free = p.n, p.next
+	default:
+		n = t.pages
+		t.pages++
+	}
+	p := &fpage[K, V]{dirty: true, n: n, typ: typ}
+	switch typ {
+	case pageData:
+		p.size = dataHdrSize
+	default:
+		p.size = indexHdrSize
+	}
+	t.cache[n] = p
+	return p, nil
+}
+
+// release returns p to the list of free pages.
+func (t *FileTree[K, V]) release(p *fpage[K, V]) {
+	t.modify(p)
+	*p = fpage[K, V]{dirty: true, n: p.n, next: t.free, typ: pageFree}
+	t.free = p.n
+}
+
+// find returns the index of k in the keys of p or the index where k would be
+// inserted.
+func (t *FileTree[K, V]) find(p *fpage[K, V], k K) (i int, ok bool) {
+	l, h := 0, len(p.ks)-1
+	for l <= h {
+		m := (l + h) >> 1
+		switch cmp := t.cmp(k, p.ks[m]); {
+		case cmp > 0:
+			l = m + 1
+		case cmp == 0:
+			return m, true
+		default:
+			h = m - 1
+		}
+	}
+	return l, false
+}
+
+// child returns the index of the child of the index page p covering k.
+func (t *FileTree[K, V]) child(p *fpage[K, V], k K) int {
+	i, ok := t.find(p, k)
+	if ok {
+		i++
+	}
+	return i
+}
+
+// fpath is a step of a root to leaf path.
+type fpath[K comparable, V interface{}] struct {
+	p *fpage[K, V]
+	i int // index of the child
+}
+
+// leaf returns the data page covering k and the path to it.
+func (t *FileTree[K, V]) leaf(k K, path []fpath[K, V]) (*fpage[K, V], []fpath[K, V], error) {
+	p, err := t.page(t.root)
+	for err == nil && p.typ == pageIndex {
+		i := t.child(p, k)
+		path = append(path, fpath[K, V]{p, i})
+		p, err = t.page(p.ch[i])
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.typ != pageData {
+		return nil, nil, fmt.Errorf("FileTree: page %d is not a data page", p.n)
+	}
+
+	return p, path, nil
+}
+
+// Delete removes the k's KV pair, if it exists, in which case Delete returns
+// true.
+func (t *FileTree[K, V]) Delete(k K) (ok bool, err error) {
+	t.trim()
+	if t.root == 0 {
+		return false, nil
+	}
+
+	var a [maxPath]fpath[K, V]
+	p, path, err := t.leaf(k, a[:0])
+	if err != nil {
+		return false, err
+	}
+
+	i, ok := t.find(p, k)
+	if !ok {
+		return false, nil
+	}
+
+	// All the pages to be modified are read before modifying any of them,
+	// so a read error leaves the tree unchanged.
+	var prev, next *fpage[K, V]
+	var chain []*fpage[K, V] // the new root and the pages replaced by it
+	if len(p.ks) == 1 {
+		if prev, next, chain, err = t.deletePages(p, path); err != nil {
+			return false, err
+		}
+	}
+
+	t.modify(p)
+	t.ver++
+	t.count--
+	p.size -= itemSize(p.ksz[i]) + itemSize(p.vsz[i])
+	p.ks = append(p.ks[:i], p.ks[i+1:]...)
+	p.ksz = append(p.ksz[:i], p.ksz[i+1:]...)
+	p.vs = append(p.vs[:i], p.vs[i+1:]...)
+	p.vsz = append(p.vsz[:i], p.vsz[i+1:]...)
+	if len(p.ks) != 0 {
+		return true, nil
+	}
+
+	// Unlink the empty data page.
+	if prev != nil {
+		t.modify(prev)
+		prev.next = p.next
+	}
+	if next != nil {
+		t.modify(next)
+		next.prev = p.prev
+	}
+	t.release(p)
+
+	// Remove the empty pages from their parents.
+	for j := len(path) - 1; ; j-- {
+		if j < 0 {
+			t.root = 0
+			return true, nil
+		}
+
+		q, i := path[j].p, path[j].i
+		t.modify(q)
+		switch {
+		case len(q.ks) == 0:
+			t.release(q)
+			continue
+		case i == 0:
+			q.size -= itemSize(q.ksz[0]) + 8
+			q.ks, q.ksz, q.ch = q.ks[1:], q.ksz[1:], q.ch[1:]
+		default:
+			q.size -= itemSize(q.ksz[i-1]) + 8
+			q.ks = append(q.ks[:i-1], q.ks[i:]...)
+			q.ksz = append(q.ksz[:i-1], q.ksz[i:]...)
+			q.ch = append(q.ch[:i], q.ch[i+1:]...)
+		}
+		break
+	}
+
+	// Shrink the tree while the root has a single child.
+	if len(chain) != 0 {
+		t.release(path[0].p)
+		for _, q := range chain[:len(chain)-1] {
+			t.release(q)
+		}
+		t.root = chain[len(chain)-1].n
+	}
+	return true, nil
+}
+
+// deletePages reads the pages modified by deleting the last item of the data
+// page p. path leads to p. prev and next are the neighbours of p, if any. If
+// the root is left with a single child, chain is the path from that child to
+// the new root, which is the first page not being an index page with a single
+// child.
+func (t *FileTree[K, V]) deletePages(p *fpage[K, V], path []fpath[K, V]) (prev, next *fpage[K, V], chain []*fpage[K, V], err error) {
+	if p.prev != 0 {
+		if prev, err = t.page(p.prev); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if p.next != 0 {
+		if next, err = t.page(p.next); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	j := len(path) - 1
+	for j >= 0 && len(path[j].p.ks) == 0 {
+		j--
+	}
+	if j != 0 || len(path[0].p.ks) != 1 {
+		return prev, next, nil, nil
+	}
+
+	n := path[0].p.ch[1-path[0].i]
+	for {
+		q, err := t.page(n)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		chain = append(chain, q)
+		if q.typ != pageIndex || len(q.ks) != 0 {
+			return prev, next, chain, nil
+		}
+
+		n = q.ch[0]
+	}
+}
+
+// Get returns the value associated with k and true if it exists. Otherwise Get
+// returns (zero-value, false).
+func (t *FileTree[K, V]) Get(k K) (v V, ok bool, err error) {
+	t.trim()
+	if t.root == 0 {
+		return v, false, nil
+	}
+
+	var a [maxPath]fpath[K, V]
+	p, _, err := t.leaf(k, a[:0])
+	if err != nil {
+		return v, false, err
+	}
+
+	if i, ok := t.find(p, k); ok {
+		return p.vs[i], true, nil
+	}
+
+	return v, false, nil
+}
+
+// Len returns the number of items in the tree.
+func (t *FileTree[K, V]) Len() int {
+	return int(t.count)
+}
+
+// Set sets the value associated with k.
+func (t *FileTree[K, V]) Set(k K, v V) error {
+	t.trim()
+	var err error
+	if t.item, err = t.kc.Append(t.item[:0], k); err != nil {
+		return err
+	}
+
+	ksz := len(t.item)
+	if t.item, err = t.vc.Append(t.item[:0], v); err != nil {
+		return err
+	}
+
+	vsz := len(t.item)
+	if itemSize(ksz)+itemSize(vsz) > (t.pageSize-dataHdrSize)/4 {
+		return fmt.Errorf("FileTree: item too big")
+	}
+
+	if t.root == 0 {
+		p, err := t.alloc(pageData)
+		if err != nil {
+			return err
+		}
+
+		t.root = p.n
+	}
+
+	var a [maxPath]fpath[K, V]
+	p, path, err := t.leaf(k, a[:0])
+	if err != nil {
+		return err
+	}
+
+	t.modify(p)
+	i, ok := t.find(p, k)
+	switch {
+	case ok:
+		p.size += itemSize(vsz) - itemSize(p.vsz[i])
+		p.vs[i], p.vsz[i] = v, vsz
+	default:
+		t.ver++
+		t.count++
+		p.size += itemSize(ksz) + itemSize(vsz)
+		p.ks = append(p.ks[:i], append([]K{k}, p.ks[i:]...)...)
+		p.ksz = append(p.ksz[:i], append([]int{ksz}, p.ksz[i:]...)...)
+		p.vs = append(p.vs[:i], append([]V{v}, p.vs[i:]...)...)
+		p.vsz = append(p.vsz[:i], append([]int{vsz}, p.vsz[i:]...)...)
+	}
+	if p.size <= t.pageSize {
+		return nil
+	}
+
+	t.ver++
+	return t.split(p, path)
+}
+
+// split splits the overflowing page p. path leads to p.
+func (t *FileTree[K, V]) split(p *fpage[K, V], path []fpath[K, V]) error {
+	for {
+		r, err := t.alloc(p.typ)
+		if err != nil {
+			return err
+		}
+
+		// Find the middle of p by size.
+		m, s := 0, 0
+		for ; s < (p.size-r.size)/2; m++ {
+			switch p.typ {
+			case pageData:
+				s += itemSize(p.ksz[m]) + itemSize(p.vsz[m])
+			default:
+				s += itemSize(p.ksz[m]) + 8
+			}
+		}
+		var sep K
+		var sepsz int
+		switch p.typ {
+		case pageData:
+			r.ks, r.ksz = append(r.ks, p.ks[m:]...), append(r.ksz, p.ksz[m:]...)
+			r.vs, r.vsz = append(r.vs, p.vs[m:]...), append(r.vsz, p.vsz[m:]...)
+			p.ks, p.ksz, p.vs, p.vsz = p.ks[:m:m], p.ksz[:m:m], p.vs[:m:m], p.vsz[:m:m]
+			for i := range r.ks {
+				r.size += itemSize(r.ksz[i]) + itemSize(r.vsz[i])
+			}
+			p.size = dataHdrSize + s
+			sep, sepsz = r.ks[0], r.ksz[0]
+			if r.next = p.next; r.next != 0 {
+				q, err := t.page(r.next)
+				if err != nil {
+					return err
+				}
+
+				t.modify(q)
+				q.prev = r.n
+			}
+			p.next, r.prev = r.n, p.n
+		default:
+			// Key m moves up.
+			m--
+			sep, sepsz = p.ks[m], p.ksz[m]
+			r.ks, r.ksz = append(r.ks, p.ks[m+1:]...), append(r.ksz, p.ksz[m+1:]...)
+			r.ch = append(r.ch, p.ch[m+1:]...)
+			p.ks, p.ksz, p.ch = p.ks[:m:m], p.ksz[:m:m], p.ch[:m+1:m+1]
+			for i := range r.ks {
+				r.size += itemSize(r.ksz[i]) + 8
+			}
+			p.size = indexHdrSize + s - itemSize(sepsz) - 8
+		}
+
+		if len(path) == 0 {
+			z, err := t.alloc(pageIndex)
+			if err != nil {
+				return err
+			}
+
+			z.ks, z.ksz, z.ch = []K{sep}, []int{sepsz}, []uint64{p.n, r.n}
+			z.size += itemSize(sepsz) + 8
+			t.root = z.n
+			return nil
+		}
+
+		q, i := path[len(path)-1].p, path[len(path)-1].i
+		path = path[:len(path)-1]
+		t.modify(q)
+		q.ks = append(q.ks[:i], append([]K{sep}, q.ks[i:]...)...)
+		q.ksz = append(q.ksz[:i], append([]int{sepsz}, q.ksz[i:]...)...)
+		q.ch = append(q.ch[:i+1], append([]uint64{r.n}, q.ch[i+1:]...)...)
+		if q.size += itemSize(sepsz) + 8; q.size <= t.pageSize {
+			return nil
+		}
+
+		p = q
+	}
+}
+
+// Seek returns a FileEnumerator positioned on an item such that k >= item's
+// key. ok reports if k == item.key The FileEnumerator's position is possibly
+// after the last item in the tree.
+func (t *FileTree[K, V]) Seek(k K) (e *FileEnumerator[K, V], ok bool, err error) {
+	t.trim()
+	e = &FileEnumerator[K, V]{k: k, t: t, ver: t.ver}
+	if t.root == 0 {
+		return e, false, nil
+	}
+
+	var a [maxPath]fpath[K, V]
+	p, _, err := t.leaf(k, a[:0])
+	if err != nil {
+		return nil, false, err
+	}
+
+	e.i, e.hit = t.find(p, k)
+	e.q = p.n
+	return e, e.hit, nil
+}
+
+// SeekFirst returns an enumerator positioned on the first KV pair in the tree,
+// if any. For an empty tree, err == io.EOF is returned and e will be nil.
+func (t *FileTree[K, V]) SeekFirst() (e *FileEnumerator[K, V], err error) {
+	return t.seekEdge(0)
+}
+
+// SeekLast returns an enumerator positioned on the last KV pair in the tree,
+// if any. For an empty tree, err == io.EOF is returned and e will be nil.
+func (t *FileTree[K, V]) SeekLast() (e *FileEnumerator[K, V], err error) {
+	return t.seekEdge(-1)
+}
+
+func (t *FileTree[K, V]) seekEdge(edge int) (e *FileEnumerator[K, V], err error) {
+	t.trim()
+	if t.root == 0 {
+		return nil, io.EOF
+	}
+
+	p, err := t.page(t.root)
+	for err == nil && p.typ == pageIndex {
+		i := 0
+		if edge < 0 {
+			i = len(p.ch) - 1
+		}
+		p, err = t.page(p.ch[i])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	i := 0
+	if edge < 0 {
+		i = len(p.ks) - 1
+	}
+	return &FileEnumerator[K, V]{hit: true, i: i, k: p.ks[i], q: p.n, t: t, ver: t.ver}, nil
+}
+
+// FileEnumerator captures the state of enumerating a FileTree. It behaves like
+// an Enumerator. Any error reading the tree is returned by Next or Prev and it
+// is sticky the same way as io.EOF.
+type FileEnumerator[K comparable, V interface{}] struct {
+	err error
+	hit bool
+	i   int
+	k   K
+	q   uint64 // data page
+	t   *FileTree[K, V]
+	ver int64
+}
+
+// Close zeroes e, dropping its reference to the tree. An enumerator pins no
+// pages in the page cache, so calling Close is optional. e must not be used
+// afterwards.
+func (e *FileEnumerator[K, V]) Close() {
+	*e = FileEnumerator[K, V]{}
+}
+
+// Next returns the currently enumerated item, if it exists and moves to the
+// next item in the key collation order. If there is no item to return, err ==
+// io.EOF is returned.
+func (e *FileEnumerator[K, V]) Next() (k K, v V, err error) {
+	if err = e.err; err != nil {
+		return
+	}
+
+	e.t.trim()
+	if e.ver != e.t.ver {
+		if err = e.resync(); err != nil {
+			return
+		}
+	}
+	p, err := e.page()
+	if err != nil {
+		return
+	}
+
+	if e.i >= len(p.ks) {
+		if p, err = e.next(p); err != nil {
+			return
+		}
+	}
+
+	k, v = p.ks[e.i], p.vs[e.i]
+	e.k, e.hit = k, true
+	e.next(p)
+	return k, v, nil
+}
+
+// Prev returns the currently enumerated item, if it exists and moves to the
+// previous item in the key collation order. If there is no item to return, err
+// == io.EOF is returned.
+func (e *FileEnumerator[K, V]) Prev() (k K, v V, err error) {
+	if err = e.err; err != nil {
+		return
+	}
+
+	e.t.trim()
+	if e.ver != e.t.ver {
+		if err = e.resync(); err != nil {
+			return
+		}
+	}
+	p, err := e.page()
+	if err != nil {
+		return
+	}
+
+	if !e.hit {
+		// move to previous because Seek overshoots if there's no hit
+		if p, err = e.prev(p); err != nil {
+			return
+		}
+	}
+
+	if e.i >= len(p.ks) {
+		if p, err = e.prev(p); err != nil {
+			return
+		}
+	}
+
+	k, v = p.ks[e.i], p.vs[e.i]
+	e.k, e.hit = k, true
+	e.prev(p)
+	return k, v, nil
+}
+
+// page returns the data page of e.
+func (e *FileEnumerator[K, V]) page() (*fpage[K, V], error) {
+	if e.q == 0 {
+		e.err = io.EOF
+		return nil, io.EOF
+	}
+
+	p, err := e.t.page(e.q)
+	if err != nil {
+		e.err = err
+	}
+	return p, err
+}
+
+func (e *FileEnumerator[K, V]) next(p *fpage[K, V]) (*fpage[K, V], error) {
+	if e.i < len(p.ks)-1 {
+		e.i++
+		return p, nil
+	}
+
+	e.q, e.i = p.next, 0
+	return e.page()
+}
+
+func (e *FileEnumerator[K, V]) prev(p *fpage[K, V]) (*fpage[K, V], error) {
+	if e.i > 0 {
+		e.i--
+		return p, nil
+	}
+
+	if e.q = p.prev; e.q == 0 {
+		e.err = io.EOF
+		return nil, io.EOF
+	}
+
+	p, err := e.page()
+	if err == nil {
+		e.i = len(p.ks) - 1
+	}
+	return p, err
+}
+
+// resync repositions e at e.k after the tree was mutated.
+func (e *FileEnumerator[K, V]) resync() error {
+	f, _, err := e.t.Seek(e.k)
+	if err != nil {
+		e.err = err
+		return err
+	}
+
+	*e = *f
+	return nil
+}
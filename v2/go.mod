@@ -1,10 +1,7 @@
 module modernc.org/b/v2
 
-go 1.18
+go 1.23
 
-require (
-	modernc.org/mathutil v1.4.1
-	modernc.org/strutil v1.1.1
-)
+require modernc.org/mathutil v1.4.1
 
 require github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
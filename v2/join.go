@@ -0,0 +1,73 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"fmt"
+)
+
+// Join moves all the KV pairs of other to the end of t. The pages of other are
+// grafted onto t at the matching height, Join is O(log n). All keys of other
+// must collate after all keys of t and both trees must be compatible: they
+// must have the same page sizes and either none or both of them must be
+// AugmentedTrees with Monoids of the same type. Otherwise Join returns an
+// error and neither tree is modified. Join cannot check that both trees use
+// the same compare function and the same Monoid. other is left empty.
+//
+// The grafted pages of other belong to another generation than t, see
+// Snapshot. The first mutation of each of them copies the page, so the first
+// writes to the joined part of t cost more than the later ones.
+func (t *Tree[K, V]) Join(other *Tree[K, V]) error {
+	t.mutating()
+	other.mutating()
+	if t.kd != other.kd || t.kx != other.kx {
+		return fmt.Errorf("Join: page sizes differ")
+	}
+
+	if !t.sameAug(other) {
+		return fmt.Errorf("Join: aggregates differ")
+	}
+
+	if other.r == nil {
+		return nil
+	}
+
+	sep, _ := other.First()
+	if t.r != nil {
+		if k, _ := t.Last(); t.cmp(k, sep) >= 0 {
+			return fmt.Errorf("Join: key ranges overlap")
+		}
+	}
+
+	r, _ := t.join(t.r, t.height(t.r), sep, other.r, other.height(other.r))
+	t.setRoot(r)
+	t.shared = true
+	other.c, other.first, other.last, other.r = 0, nil, nil, nil
+	other.gen = newGen() // The pages of other now belong to t.
+	other.ver++
+	return nil
+}
+
+// SplitAt moves the KV pairs of t having keys collating before k to a newly
+// created tree left and the remaining ones to a newly created tree right. The
+// pages of t are reused, SplitAt is O(log n). t is left empty.
+//
+// The pages of left and right belong to another generation than the trees
+// themselves, see Snapshot. The first mutation of each of them copies the
+// page, so the first writes to left and right cost more than the later ones.
+func (t *Tree[K, V]) SplitAt(k K) (left, right *Tree[K, V]) {
+	t.mutating()
+	left, right = t.empty(), t.empty()
+	if t.r != nil {
+		l, _, r, _ := t.cut(t.r, t.height(t.r), k)
+		left.setRoot(l)
+		right.setRoot(r)
+		left.shared, right.shared = true, true
+	}
+	t.c, t.first, t.last, t.r = 0, nil, nil, nil
+	t.gen = newGen() // The pages of t now belong to left and right.
+	t.ver++
+	return left, right
+}
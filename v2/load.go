@@ -0,0 +1,178 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"fmt"
+	"iter"
+	"math"
+)
+
+// TreeFromSorted returns a newly created Tree having all the KV pairs produced
+// by seq. The keys must be produced in strictly increasing order according to
+// cmp, otherwise TreeFromSorted returns an error.
+//
+// The tree is built bottom-up in a single pass over seq, without searching
+// for the insertion points and without splitting pages, which makes it much
+// faster than calling Set for every item. The pages are filled completely,
+// see TreeFromSortedWithOptions for loading a tree that will be modified
+// later.
+func TreeFromSorted[K comparable, V interface{}](cmp Cmp[K], seq iter.Seq2[K, V]) (*Tree[K, V], error) {
+	return TreeFromSortedWithOptions(cmp, seq, LoadOptions{})
+}
+
+// LoadOptions amend the behavior of TreeFromSortedWithOptions.
+type LoadOptions struct {
+	// Options selects the page sizes of the tree.
+	Options
+
+	// Fill is the fraction of the capacity of the pages filled by the
+	// loader. It must be in [0.5, 1], zero selects 1. The first insert
+	// into a completely filled page splits it, so a tree that will be
+	// modified after loading is better loaded with a smaller fill factor.
+	Fill float64
+}
+
+// TreeFromSortedWithOptions is like TreeFromSorted, but the page sizes of the
+// tree and the fill factor of its pages are selected by o. The last two pages
+// of every level may be filled differently, they are balanced against each
+// other when seq ends.
+func TreeFromSortedWithOptions[K comparable, V interface{}](cmp Cmp[K], seq iter.Seq2[K, V], o LoadOptions) (*Tree[K, V], error) {
+	fill := o.Fill
+	if fill == 0 {
+		fill = 1
+	}
+	if !(fill >= 0.5 && fill <= 1) {
+		return nil, fmt.Errorf("TreeFromSorted: fill factor %v not in [0.5, 1]", o.Fill)
+	}
+
+	t := TreeNewWithOptions[K, V](cmp, o.Options)
+	l := newLoader(t, fill)
+	var err error
+	var last K
+	seq(func(k K, v V) bool {
+		if t.c != 0 && cmp(last, k) >= 0 {
+			err = fmt.Errorf("TreeFromSorted: item #%d: key out of order", t.c)
+			return false
+		}
+
+		last = k
+		l.add(k, v)
+		return true
+	})
+	if err != nil {
+		t.r = l.finish()
+		t.Close()
+		return nil, err
+	}
+
+	t.setRoot(l.finish())
+	return t, nil
+}
+
+// loader builds a tree bottom-up from sorted items. The pages are added at
+// the right edge of every level. The last two pages of a level are kept
+// pending so that the last page can be balanced against its left sibling
+// when the input ends.
+type loader[K comparable, V interface{}] struct {
+	dmax   int // items in a filled data page
+	levels []level[K]
+	t      *Tree[K, V]
+	xmax   int // keys in a filled index page
+}
+
+// newLoader returns a loader filling the pages of t to the fraction fill of
+// their capacity, but not below their minimum fill.
+func newLoader[K comparable, V interface{}](t *Tree[K, V], fill float64) *loader[K, V] {
+	return &loader[K, V]{
+		dmax: max(int(math.Round(fill*float64(2*t.kd))), t.kd),
+		t:    t,
+		xmax: max(int(math.Round(fill*float64(2*t.kx))), t.kx),
+	}
+}
+
+type level[K comparable] struct {
+	cur   interface{} // The page being filled.
+	curK  K           // The first key in the subtree of cur.
+	prev  interface{} // The filled page preceding cur.
+	prevK K           // The first key in the subtree of prev.
+}
+
+func (l *loader[K, V]) add(k K, v V) {
+	t := l.t
+	if len(l.levels) == 0 {
+		l.levels = append(l.levels, level[K]{})
+	}
+	lv := &l.levels[0]
+	q, _ := lv.cur.(*d[K, V])
+	if q == nil || q.c == l.dmax {
+		z := t.newD()
+		if z.p = t.last; z.p != nil {
+			z.p.n = z
+		}
+		t.last = z
+		l.shift(0, z, k)
+		q = z
+	}
+	q.d[q.c] = de[K, V]{k: k, v: v}
+	q.c++
+	t.c++
+}
+
+// shift makes page q, having the first key k in its subtree, the page being
+// filled at level i.
+func (l *loader[K, V]) shift(i int, q interface{}, k K) {
+	lv := &l.levels[i]
+	if lv.prev != nil {
+		l.emit(i+1, lv.prevK, lv.prev)
+		lv = &l.levels[i]
+	}
+	lv.prev, lv.prevK = lv.cur, lv.curK
+	lv.cur, lv.curK = q, k
+}
+
+// emit adds page ch, having the first key sep in its subtree, to level i.
+func (l *loader[K, V]) emit(i int, sep K, ch interface{}) {
+	t := l.t
+	if i == len(l.levels) {
+		l.levels = append(l.levels, level[K]{})
+	}
+	q, _ := l.levels[i].cur.(*x[K, V])
+	if q == nil || q.c == l.xmax {
+		q = t.newX(ch)
+		q.n = t.items(ch)
+		l.shift(i, q, sep)
+		return
+	}
+
+	q.x[q.c].k = sep
+	q.c++
+	q.x[q.c].ch = ch
+	q.n += t.items(ch)
+}
+
+// finish completes all the levels and returns the root page, if any.
+func (l *loader[K, V]) finish() interface{} {
+	for i := 0; i < len(l.levels); i++ {
+		lv := l.levels[i]
+		if lv.prev == nil {
+			if q, ok := lv.cur.(*x[K, V]); ok && q.c == 0 {
+				// The two pages of the level below were merged.
+				r := q.x[0].ch
+				l.t.freeX(q)
+				return r
+			}
+
+			return lv.cur
+		}
+
+		sep, z := l.t.balance(lv.prev, lv.curK, lv.cur)
+		l.emit(i+1, lv.prevK, lv.prev)
+		if z != nil {
+			l.emit(i+1, sep, z)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// The log of a LogTree is
+//
+//	magic    "\x00BPL"
+//	version  uvarint
+//	records  any number of
+//
+// A record is
+//
+//	size     uvarint, the size of payload
+//	payload  op, byte, followed by the key and, if op is logSet, the value,
+//	         as encoded by the codecs
+//	crc      uint32, little endian, CRC-32C of payload
+//
+// Every record holds the outcome of a mutation, not the mutation itself, so
+// replaying a log onto a checkpoint image taken at any point covered by the
+// log produces the same tree as replaying it onto the image taken at the
+// start of the log.
+
+const (
+	logMagic   = "\x00BPL"
+	logVersion = 1
+
+	logSet    = 1
+	logDelete = 2
+)
+
+// LogOptions amend the behavior of LogTreeOpen.
+type LogOptions struct {
+	// Sync makes every mutation sync the log after writing its record. If
+	// Sync is false, the records are only as durable as the caller makes
+	// them by calling LogTree.Sync.
+	Sync bool
+}
+
+// LogTree is a Tree whose mutations are recorded in a write-ahead log. Set,
+// Delete and Put write a record of their outcome to the log before they
+// mutate the tree. After a crash, LogTreeOpen replays the log onto the tree
+// decoded from the last checkpoint image.
+//
+// If writing to the log fails, the error is returned by that and all the
+// later mutations, which then do not mutate the tree. A LogTree follows the
+// same concurrency rules as Tree.
+//
+// A LogTree logs the mutations of an in-memory Tree only. It has nothing to
+// do with the durability of a FileTree, which does not use a log. The changes
+// made to a FileTree are durable once FileTree.Commit returns, the changes
+// made after the last Commit are lost in a crash.
+type LogTree[K comparable, V interface{}] struct {
+	buf  []byte
+	err  error // sticky
+	f    File
+	kc   Codec[K]
+	off  int64 // end of the log
+	sync bool
+	t    *Tree[K, V]
+	vc   Codec[V]
+}
+
+// LogTreeOpen returns a LogTree mutating t and writing its log to f. t is the
+// tree decoded from the last checkpoint image, see LogTree.Checkpoint, or an
+// empty tree if there is none. The records in f are replayed onto t. A torn
+// or corrupted record and all the records after it are discarded and the log
+// is truncated before the first of them. The keys and values are encoded by
+// kc and vc. o may be nil.
+func LogTreeOpen[K comparable, V interface{}](t *Tree[K, V], f File, kc Codec[K], vc Codec[V], o *LogOptions) (*LogTree[K, V], error) {
+	if o == nil {
+		o = &LogOptions{}
+	}
+	l := &LogTree[K, V]{f: f, kc: kc, sync: o.Sync, t: t, vc: vc}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := fi.Size()
+	hdr := binary.AppendUvarint([]byte(logMagic), logVersion)
+	if size < int64(len(hdr)) {
+		// A new log or a torn write of its header.
+		if err := l.truncate(hdr); err != nil {
+			return nil, err
+		}
+
+		return l, nil
+	}
+
+	lr := &logReader{r: bufio.NewReader(io.NewSectionReader(f, 0, size))}
+	b := make([]byte, len(hdr))
+	if _, err := io.ReadFull(lr, b); err != nil {
+		return nil, fmt.Errorf("LogTreeOpen: reading header: %w", eof(err))
+	}
+
+	if string(b) != string(hdr) {
+		return nil, fmt.Errorf("LogTreeOpen: invalid header")
+	}
+
+	for {
+		l.off = lr.off
+		n, err := binary.ReadUvarint(lr)
+		if err == io.EOF {
+			return l, nil
+		}
+
+		if err != nil || n > uint64(size-lr.off) {
+			break
+		}
+
+		if uint64(cap(b)) < n+4 {
+			b = make([]byte, n+4)
+		}
+		b = b[:n+4]
+		if _, err := io.ReadFull(lr, b); err != nil || crc32.Checksum(b[:n], crcTable) != binary.LittleEndian.Uint32(b[n:]) {
+			break
+		}
+
+		if err := l.replay(b[:n]); err != nil {
+			return nil, fmt.Errorf("LogTreeOpen: record at offset %d: %w", l.off, err)
+		}
+	}
+
+	// Drop the torn tail.
+	if err := f.Truncate(l.off); err != nil {
+		return nil, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// logReader counts the bytes read.
+type logReader struct {
+	off int64
+	r   *bufio.Reader
+}
+
+func (r *logReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *logReader) ReadByte() (c byte, err error) {
+	if c, err = r.r.ReadByte(); err == nil {
+		r.off++
+	}
+	return c, err
+}
+
+// replay applies the record payload b to the tree.
+func (l *LogTree[K, V]) replay(b []byte) error {
+	if len(b) == 0 {
+		return fmt.Errorf("empty record")
+	}
+
+	op := b[0]
+	k, n, err := l.kc.Decode(b[1:])
+	if err != nil {
+		return err
+	}
+
+	b = b[1+n:]
+	switch op {
+	case logSet:
+		v, n, err := l.vc.Decode(b)
+		if err != nil {
+			return err
+		}
+
+		if n != len(b) {
+			return fmt.Errorf("invalid size")
+		}
+
+		l.t.Set(k, v)
+	case logDelete:
+		if len(b) != 0 {
+			return fmt.Errorf("invalid size")
+		}
+
+		l.t.Delete(k)
+	default:
+		return fmt.Errorf("invalid op %d", op)
+	}
+	return nil
+}
+
+// truncate replaces the log by b and syncs it.
+func (l *LogTree[K, V]) truncate(b []byte) error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := l.f.WriteAt(b, 0); err != nil {
+		return err
+	}
+
+	l.off = int64(len(b))
+	return l.f.Sync()
+}
+
+// write appends a record of op to the log.
+func (l *LogTree[K, V]) write(op byte, k K, v V) (err error) {
+	if l.err != nil {
+		return l.err
+	}
+
+	defer func() {
+		if err != nil {
+			l.err = err
+		}
+	}()
+
+	p := append(l.buf[:0], op)
+	if p, err = l.kc.Append(p, k); err != nil {
+		return err
+	}
+
+	if op == logSet {
+		if p, err = l.vc.Append(p, v); err != nil {
+			return err
+		}
+	}
+
+	// The record is assembled after the payload in the same buffer.
+	n := len(p)
+	p = binary.AppendUvarint(p, uint64(n))
+	p = append(p, p[:n]...)
+	p = binary.LittleEndian.AppendUint32(p, crc32.Checksum(p[:n], crcTable))
+	l.buf = p
+	if _, err = l.f.WriteAt(p[n:], l.off); err != nil {
+		return err
+	}
+
+	l.off += int64(len(p) - n)
+	if l.sync {
+		return l.f.Sync()
+	}
+
+	return nil
+}
+
+// Checkpoint calls save to write an image of the tree, eg. using Tree.Encode,
+// and truncates the log if save returns nil. save must make the image durable
+// before returning and it must not destroy the previous image before the new
+// one is complete, eg. by writing to a temporary file and renaming it.
+//
+// A crash after save returns but before the log is truncated is harmless, the
+// log then replays onto the new image correctly.
+func (l *LogTree[K, V]) Checkpoint(save func(t *Tree[K, V]) error) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	if err := save(l.t); err != nil {
+		return err
+	}
+
+	if err := l.truncate(binary.AppendUvarint([]byte(logMagic), logVersion)); err != nil {
+		l.err = err
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the log.
+func (l *LogTree[K, V]) Close() error {
+	err := l.f.Close()
+	*l = LogTree[K, V]{}
+	return err
+}
+
+// Delete removes the k's KV pair, if it exists, in which case Delete returns
+// true.
+func (l *LogTree[K, V]) Delete(k K) (ok bool, err error) {
+	if _, ok := l.t.Get(k); !ok {
+		return false, l.err
+	}
+
+	var v V
+	if err := l.write(logDelete, k, v); err != nil {
+		return false, err
+	}
+
+	return l.t.Delete(k), nil
+}
+
+// Put combines Get and Set in a more efficient way where the tree is walked
+// only once. See Tree.Put. The new value is written to the log after upd
+// returns and before the tree is mutated.
+func (l *LogTree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool, err error) {
+	if l.err != nil {
+		return oldV, false, l.err
+	}
+
+	oldV, written = l.t.Put(k, func(oldV V, exists bool) (newV V, write bool) {
+		if newV, write = upd(oldV, exists); !write {
+			return newV, false
+		}
+
+		if err = l.write(logSet, k, newV); err != nil {
+			return newV, false
+		}
+
+		return newV, true
+	})
+	return oldV, written, err
+}
+
+// Set sets the value associated with k.
+func (l *LogTree[K, V]) Set(k K, v V) error {
+	if err := l.write(logSet, k, v); err != nil {
+		return err
+	}
+
+	l.t.Set(k, v)
+	return nil
+}
+
+// Sync syncs the log.
+func (l *LogTree[K, V]) Sync() error {
+	if l.err != nil {
+		return l.err
+	}
+
+	return l.f.Sync()
+}
+
+// Tree returns the tree of l. The tree can be read freely, but mutating it
+// directly bypasses the log.
+func (l *LogTree[K, V]) Tree() *Tree[K, V] {
+	return l.t
+}
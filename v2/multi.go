@@ -0,0 +1,249 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"iter"
+	"math"
+)
+
+// MultiTree is a B+tree storing any number of values under equal keys. The
+// values of equal keys are kept in the order they were added.
+//
+// Every item is stored in a Tree under its key extended with a sequence
+// number, so the items of equal keys are ordinary distinct items of the tree
+// and they can span any number of data pages. MultiTree follows the same
+// concurrency rules as Tree.
+type MultiTree[K comparable, V interface{}] struct {
+	seq uint64
+	t   *Tree[mkey[K], V]
+}
+
+// mkey is a key of a MultiTree extended by the sequence number of its item.
+type mkey[K comparable] struct {
+	k   K
+	seq uint64
+}
+
+// MultiTreeNew returns a newly created, empty MultiTree. The compare function
+// is used for key collation.
+func MultiTreeNew[K comparable, V interface{}](cmp Cmp[K]) *MultiTree[K, V] {
+	return &MultiTree[K, V]{t: TreeNew[mkey[K], V](func(a, b mkey[K]) int {
+		if c := cmp(a.k, b.k); c != 0 {
+			return c
+		}
+
+		switch {
+		case a.seq < b.seq:
+			return -1
+		case a.seq > b.seq:
+			return 1
+		}
+		return 0
+	})}
+}
+
+// dups returns the bounds of the items having key k.
+func (t *MultiTree[K, V]) dups(k K) (lo mkey[K], hi Bound[mkey[K]]) {
+	return mkey[K]{k: k}, UpperBound(mkey[K]{k, math.MaxUint64}, true)
+}
+
+// Add adds the KV pair to the tree. Any existing values of k are kept, v is
+// added after them.
+func (t *MultiTree[K, V]) Add(k K, v V) {
+	t.seq++
+	t.t.Set(mkey[K]{k, t.seq}, v)
+}
+
+// All returns an iterator over the KV pairs of the tree in the key collating
+// order. The values of equal keys are returned in the order they were added.
+func (t *MultiTree[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v := range t.t.All() {
+			if !yield(k.k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the KV pairs of the tree in the reverse
+// key collating order and the reverse order of adding the values of equal
+// keys.
+func (t *MultiTree[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v := range t.t.Backward() {
+			if !yield(k.k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Clear removes all K/V pairs from the tree.
+func (t *MultiTree[K, V]) Clear() {
+	t.t.Clear()
+}
+
+// Count returns the number of values of k.
+func (t *MultiTree[K, V]) Count(k K) int {
+	lo, hi := t.dups(k)
+	return t.t.Rank(hi.k) - t.t.Rank(lo)
+}
+
+// DeleteAll removes all values of k and returns their number.
+func (t *MultiTree[K, V]) DeleteAll(k K) int {
+	lo, hi := t.dups(k)
+	return t.t.DeleteRange(lo, hi.k)
+}
+
+// DeleteOne removes the first value of k for which eq returns true, if it
+// exists, in which case DeleteOne returns true.
+func (t *MultiTree[K, V]) DeleteOne(k K, eq func(v V) bool) bool {
+	lo, hi := t.dups(k)
+	e, _ := t.t.Seek(lo, hi)
+	defer e.Close()
+	for {
+		_, v, err := e.Next()
+		if err != nil {
+			return false
+		}
+
+		if eq(v) {
+			return e.Delete()
+		}
+	}
+}
+
+// First returns the first item of the tree in the key collating order, or
+// (zero-value, zero-value) if the tree is empty.
+func (t *MultiTree[K, V]) First() (k K, v V) {
+	mk, v := t.t.First()
+	return mk.k, v
+}
+
+// Get returns the first value of k and true if it exists. Otherwise Get
+// returns (zero-value, false).
+func (t *MultiTree[K, V]) Get(k K) (v V, ok bool) {
+	mk, v, ok := t.t.Ceil(mkey[K]{k: k})
+	if !ok || t.t.cmp(mk, mkey[K]{k, math.MaxUint64}) > 0 {
+		var z V
+		return z, false
+	}
+
+	return v, true
+}
+
+// GetAll returns all values of k in the order they were added.
+func (t *MultiTree[K, V]) GetAll(k K) (r []V) {
+	for v := range t.Values(k) {
+		r = append(r, v)
+	}
+	return r
+}
+
+// Last returns the last item of the tree in the key collating order, or
+// (zero-value, zero-value) if the tree is empty.
+func (t *MultiTree[K, V]) Last() (k K, v V) {
+	mk, v := t.t.Last()
+	return mk.k, v
+}
+
+// Len returns the number of items in the tree.
+func (t *MultiTree[K, V]) Len() int {
+	return t.t.Len()
+}
+
+// Seek returns a MultiEnumerator positioned on the first item such that k >=
+// item's key. ok reports if k == item.key The MultiEnumerator's position is
+// possibly after the last item in the tree.
+func (t *MultiTree[K, V]) Seek(k K) (e *MultiEnumerator[K, V], ok bool) {
+	lo, _ := t.dups(k)
+	f, _ := t.t.Seek(lo)
+	_, ok = t.Get(k)
+	return &MultiEnumerator[K, V]{f}, ok
+}
+
+// SeekFirst returns a MultiEnumerator positioned on the first KV pair in the
+// tree, if any. For an empty tree, err == io.EOF is returned and e will be
+// nil.
+func (t *MultiTree[K, V]) SeekFirst() (e *MultiEnumerator[K, V], err error) {
+	f, err := t.t.SeekFirst()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiEnumerator[K, V]{f}, nil
+}
+
+// SeekLast returns a MultiEnumerator positioned on the last KV pair in the
+// tree, if any. For an empty tree, err == io.EOF is returned and e will be
+// nil.
+func (t *MultiTree[K, V]) SeekLast() (e *MultiEnumerator[K, V], err error) {
+	f, err := t.t.SeekLast()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiEnumerator[K, V]{f}, nil
+}
+
+// Values returns an iterator over the values of k in the order they were
+// added.
+func (t *MultiTree[K, V]) Values(k K) iter.Seq[V] {
+	return func(yield func(V) bool) {
+		lo, hi := t.dups(k)
+		e, _ := t.t.Seek(lo, hi)
+		defer e.Close()
+		for n := 0; ; n++ {
+			_, v, err := e.advance(false, n != 0)
+			if err != nil || !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// MultiEnumerator captures the state of enumerating a MultiTree. It behaves
+// like an Enumerator, the values of equal keys are enumerated in the order
+// they were added.
+type MultiEnumerator[K comparable, V interface{}] struct {
+	e *Enumerator[mkey[K], V]
+}
+
+// Close recycles e. No references to e should exist or such references must
+// not be used afterwards.
+func (e *MultiEnumerator[K, V]) Close() {
+	e.e.Close()
+	*e = MultiEnumerator[K, V]{}
+}
+
+// Delete removes the item last returned by Next or Prev. See
+// Enumerator.Delete.
+func (e *MultiEnumerator[K, V]) Delete() bool {
+	return e.e.Delete()
+}
+
+// Next returns the currently enumerated item, if it exists and moves to the
+// next item in the key collation order. If there is no item to return, err ==
+// io.EOF is returned.
+func (e *MultiEnumerator[K, V]) Next() (k K, v V, err error) {
+	mk, v, err := e.e.Next()
+	return mk.k, v, err
+}
+
+// Prev returns the currently enumerated item, if it exists and moves to the
+// previous item in the key collation order. If there is no item to return, err
+// == io.EOF is returned.
+func (e *MultiEnumerator[K, V]) Prev() (k K, v V, err error) {
+	mk, v, err := e.e.Prev()
+	return mk.k, v, err
+}
+
+// SetValue sets the value of the item last returned by Next or Prev. See
+// Enumerator.SetValue.
+func (e *MultiEnumerator[K, V]) SetValue(v V) bool {
+	return e.e.SetValue(v)
+}
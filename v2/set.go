@@ -0,0 +1,112 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"iter"
+)
+
+// Set is an ordered set of keys. It is a Tree having no values, its data
+// pages hold only the keys. Set follows the same concurrency rules as Tree.
+type Set[K comparable] struct {
+	t *Tree[K, struct{}]
+}
+
+// SetNew returns a newly created, empty Set. The compare function is used for
+// key collation.
+func SetNew[K comparable](cmp Cmp[K]) *Set[K] {
+	return &Set[K]{TreeNew[K, struct{}](cmp)}
+}
+
+// Add adds k to the set and reports whether it was not present before.
+func (s *Set[K]) Add(k K) bool {
+	_, added := s.t.Put(k, func(_ struct{}, exists bool) (struct{}, bool) { return struct{}{}, !exists })
+	return added
+}
+
+// All returns an iterator over the keys of the set in the key collating
+// order. See Tree.All.
+func (s *Set[K]) All() iter.Seq[K] {
+	return keys(s.t.All())
+}
+
+// Backward returns an iterator over the keys of the set in the reverse key
+// collating order. See Tree.Backward.
+func (s *Set[K]) Backward() iter.Seq[K] {
+	return keys(s.t.Backward())
+}
+
+// Clear removes all keys from the set.
+func (s *Set[K]) Clear() {
+	s.t.Clear()
+}
+
+// Difference returns a newly created set having the keys of s not present in
+// o. Difference is O(m+n).
+func (s *Set[K]) Difference(o *Set[K]) *Set[K] {
+	return &Set[K]{Difference(s.t, o.t)}
+}
+
+// Has reports whether k is in the set.
+func (s *Set[K]) Has(k K) bool {
+	_, ok := s.t.Get(k)
+	return ok
+}
+
+// Intersect returns a newly created set having the keys present in both s and
+// o. Intersect is O(m+n).
+func (s *Set[K]) Intersect(o *Set[K]) *Set[K] {
+	return &Set[K]{Intersect(s.t, o.t)}
+}
+
+// Len returns the number of keys in the set.
+func (s *Set[K]) Len() int {
+	return s.t.Len()
+}
+
+// Max returns the last key of the set in the key collating order and true, or
+// (zero-value, false) if the set is empty.
+func (s *Set[K]) Max() (k K, ok bool) {
+	if k, _ = s.t.Last(); s.t.Len() != 0 {
+		ok = true
+	}
+	return k, ok
+}
+
+// Min returns the first key of the set in the key collating order and true,
+// or (zero-value, false) if the set is empty.
+func (s *Set[K]) Min() (k K, ok bool) {
+	if k, _ = s.t.First(); s.t.Len() != 0 {
+		ok = true
+	}
+	return k, ok
+}
+
+// Range returns an iterator over the keys of the set having lo <= key < hi,
+// in the key collating order. See Tree.Range.
+func (s *Set[K]) Range(lo, hi K) iter.Seq[K] {
+	return keys(s.t.Range(lo, hi))
+}
+
+// Remove removes k from the set and reports whether it was present.
+func (s *Set[K]) Remove(k K) bool {
+	return s.t.Delete(k)
+}
+
+// Union returns a newly created set having the keys of both s and o. Union is
+// O(m+n).
+func (s *Set[K]) Union(o *Set[K]) *Set[K] {
+	return &Set[K]{Union(s.t, o.t, nil)}
+}
+
+func keys[K comparable](seq iter.Seq2[K, struct{}]) iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range seq {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
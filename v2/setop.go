@@ -0,0 +1,112 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+// The set operations walk the data pages of their operands in a single
+// linear merge and feed the result directly to the bottom-up loader. Both
+// operands must use the same collation. The result uses the compare function
+// and the aggregation, if any, of the first operand. The operands are not
+// modified.
+
+// cursor walks the data pages of a tree.
+type cursor[K comparable, V interface{}] struct {
+	i int
+	q *d[K, V]
+	t *Tree[K, V]
+}
+
+func (c *cursor[K, V]) de() *de[K, V] { return &c.q.d[c.i] }
+
+func (c *cursor[K, V]) next() {
+	if c.i++; c.i == c.q.c {
+		c.q, c.i = c.t.nextD(c.q), 0
+	}
+}
+
+// rest adds all the remaining items of c to l.
+func (c *cursor[K, V]) rest(l *loader[K, V]) {
+	for ; c.q != nil; c.next() {
+		e := c.de()
+		l.add(e.k, e.v)
+	}
+}
+
+func setop[K comparable, V interface{}](a, b *Tree[K, V], f func(l *loader[K, V], ca, cb *cursor[K, V])) *Tree[K, V] {
+	t := a.empty()
+	l := newLoader(t, 1)
+	f(l, &cursor[K, V]{q: a.first, t: a}, &cursor[K, V]{q: b.first, t: b})
+	t.setRoot(l.finish())
+	return t
+}
+
+// Union returns a newly created tree having the KV pairs of both a and b. If a
+// key is present in both trees, its value in the result is resolve(key,
+// a-value, b-value), or the a-value if resolve is nil. Union is O(m+n).
+func Union[K comparable, V interface{}](a, b *Tree[K, V], resolve func(k K, va, vb V) V) *Tree[K, V] {
+	return setop(a, b, func(l *loader[K, V], ca, cb *cursor[K, V]) {
+		for ca.q != nil && cb.q != nil {
+			ea, eb := ca.de(), cb.de()
+			switch c := a.cmp(ea.k, eb.k); {
+			case c < 0:
+				l.add(ea.k, ea.v)
+				ca.next()
+			case c > 0:
+				l.add(eb.k, eb.v)
+				cb.next()
+			default:
+				v := ea.v
+				if resolve != nil {
+					v = resolve(ea.k, ea.v, eb.v)
+				}
+				l.add(ea.k, v)
+				ca.next()
+				cb.next()
+			}
+		}
+		ca.rest(l)
+		cb.rest(l)
+	})
+}
+
+// Intersect returns a newly created tree having the KV pairs of a whose keys
+// are present also in b. Intersect is O(m+n).
+func Intersect[K comparable, V interface{}](a, b *Tree[K, V]) *Tree[K, V] {
+	return setop(a, b, func(l *loader[K, V], ca, cb *cursor[K, V]) {
+		for ca.q != nil && cb.q != nil {
+			ea, eb := ca.de(), cb.de()
+			switch c := a.cmp(ea.k, eb.k); {
+			case c < 0:
+				ca.next()
+			case c > 0:
+				cb.next()
+			default:
+				l.add(ea.k, ea.v)
+				ca.next()
+				cb.next()
+			}
+		}
+	})
+}
+
+// Difference returns a newly created tree having the KV pairs of a whose keys
+// are not present in b. Difference is O(m+n).
+func Difference[K comparable, V interface{}](a, b *Tree[K, V]) *Tree[K, V] {
+	return setop(a, b, func(l *loader[K, V], ca, cb *cursor[K, V]) {
+		for ca.q != nil && cb.q != nil {
+			ea, eb := ca.de(), cb.de()
+			switch c := a.cmp(ea.k, eb.k); {
+			case c < 0:
+				l.add(ea.k, ea.v)
+				ca.next()
+			case c > 0:
+				cb.next()
+			default:
+				ca.next()
+				cb.next()
+			}
+		}
+		ca.rest(l)
+	})
+}
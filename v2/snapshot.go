@@ -0,0 +1,172 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"sync/atomic"
+)
+
+// Every page is stamped with the generation of the tree that created it. A
+// tree may modify or recycle only the pages of its current generation, all
+// other pages are shared with a snapshot, or may be, and they are copied on
+// write. Snapshot moves the tree to a new generation, which freezes all of its
+// existing pages in O(1). Generations are unique across all trees, so pages
+// moved between trees by Join or SplitAt are never mistaken as owned.
+//
+// The data page chain links are an exception: they belong to the single live
+// tree reaching a page and they are updated in place even in frozen pages.
+// Snapshots never use them, they step between data pages by descending from
+// the root.
+
+var lastGen atomic.Uint64
+
+func newGen() uint64 { return lastGen.Add(1) }
+
+// Snapshot returns a read-only view of the current content of t. The view
+// shares all pages with t, Snapshot is O(1). Subsequent mutations of t copy
+// the shared pages they modify, so the view is not affected by them.
+//
+// The methods of the view that read the tree, including enumerating it, can
+// be invoked concurrently with mutations of t without any locking. Calling a
+// mutating method of the view, except Clear and Close, panics. Clear or Close
+// release the view.
+func (t *Tree[K, V]) Snapshot() *Tree[K, V] {
+	s := t.empty()
+	s.c, s.first, s.last, s.r, s.ro = t.c, t.first, t.last, t.r, true
+	if !t.ro {
+		t.gen, t.shared = newGen(), true
+	}
+	return s
+}
+
+func (t *Tree[K, V]) mutating() {
+	if t.ro {
+		panic("b: mutating a snapshot")
+	}
+}
+
+// own returns q if it belongs to the current generation of t or its copy
+// otherwise. The page is about to be modified, its aggregate is invalidated.
+func (t *Tree[K, V]) own(q interface{}) interface{} {
+	if !t.shared && t.aug == nil {
+		// All pages belong to t and they have no aggregates. Most trees
+		// never take a snapshot, keep their mutations fast.
+		return q
+	}
+
+	return t.cow(q)
+}
+
+// cow implements own for trees sharing pages or having aggregates.
+func (t *Tree[K, V]) cow(q interface{}) interface{} {
+	switch x := q.(type) {
+	case *x[K, V]:
+		if x.gen != t.gen {
+			x = t.cloneX(x)
+			q = x
+		}
+		x.a = nil
+	case *d[K, V]:
+		if x.gen != t.gen {
+			x = t.cloneD(x)
+			q = x
+		}
+		x.a = nil
+	}
+	return q
+}
+
+// ch returns child i of p, which must belong to the current generation of t,
+// after making it belong to the current generation as well.
+func (t *Tree[K, V]) ch(p *x[K, V], i int) interface{} {
+	q := p.x[i].ch
+	if z := t.own(q); z != q {
+		p.x[i].ch = z
+		return z
+	}
+
+	return q
+}
+
+func (t *Tree[K, V]) cloneX(q *x[K, V]) *x[K, V] {
+	z := t.newX(nil)
+	zx := z.x
+	*z = *q
+	z.gen, z.x = t.gen, zx
+	copy(z.x, q.x)
+	return z
+}
+
+// cloneD returns a copy of q, which replaces q in the data page chain.
+// Enumerators positioned in q must resync.
+func (t *Tree[K, V]) cloneD(q *d[K, V]) *d[K, V] {
+	t.ver++
+	z := t.newD()
+	zd := z.d
+	*z = *q
+	z.gen, z.d = t.gen, zd
+	copy(z.d, q.d)
+	if z.p != nil {
+		z.p.n = z
+	} else {
+		t.first = z
+	}
+	if z.n != nil {
+		z.n.p = z
+	} else {
+		t.last = z
+	}
+	return z
+}
+
+// nextD returns the data page following q.
+func (t *Tree[K, V]) nextD(q *d[K, V]) *d[K, V] {
+	if !t.ro {
+		return q.n
+	}
+
+	k := q.d[q.c-1].k
+	var r interface{}
+	for p := t.r; ; {
+		switch x := p.(type) {
+		case *x[K, V]:
+			i, ok := t.find(x, k)
+			if ok {
+				i++
+			}
+			if i < x.c {
+				r = x.x[i+1].ch
+			}
+			p = x.x[i].ch
+		default:
+			return t.firstD(r)
+		}
+	}
+}
+
+// prevD returns the data page preceding q.
+func (t *Tree[K, V]) prevD(q *d[K, V]) *d[K, V] {
+	if !t.ro {
+		return q.p
+	}
+
+	k := q.d[0].k
+	var l interface{}
+	for p := t.r; ; {
+		switch x := p.(type) {
+		case *x[K, V]:
+			i, ok := t.find(x, k)
+			if ok {
+				i++
+			}
+			if i > 0 {
+				l = x.x[i-1].ch
+			}
+			p = x.x[i].ch
+		default:
+			return t.lastD(l)
+		}
+	}
+}
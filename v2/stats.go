@@ -0,0 +1,87 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"unsafe"
+)
+
+// Stats describes the shape of a tree and counts its structural changes. See
+// Tree.Stats.
+type Stats struct {
+	// Height is the number of page levels, including the data pages. It
+	// is zero for an empty tree.
+	Height int
+
+	// IndexPages and DataPages are the numbers of pages of the tree.
+	IndexPages int
+	DataPages  int
+
+	// DataFill and MinDataFill are the average and the minimum fill of the
+	// data pages, IndexFill and MinIndexFill of the index pages. The fill
+	// of a page is the number of its items, or keys in an index page,
+	// divided by the maximum number it can hold. The root page is included.
+	DataFill     float64
+	MinDataFill  float64
+	IndexFill    float64
+	MinIndexFill float64
+
+	// Bytes is the memory used by the tree and its pages, estimated from
+	// the sizes of their types. The memory referenced by the keys and
+	// values, eg. the bytes of a string, is not included.
+	Bytes int64
+
+	// Splits, Merges and Borrows count the pages split, the pages merged
+	// with a sibling and the borrow operations between siblings, each
+	// moving one or more items instead of a split or a merge, since the
+	// tree was created. The counts of a snapshot start at zero.
+	Splits  int64
+	Merges  int64
+	Borrows int64
+}
+
+// Stats returns the statistics of t. Stats reads the whole tree, it is O(n).
+func (t *Tree[K, V]) Stats() (s Stats) {
+	s.Bytes = int64(unsafe.Sizeof(*t))
+	s.Splits, s.Merges, s.Borrows = t.splits, t.merges, t.borrows
+	if t.r == nil {
+		return s
+	}
+
+	s.Height = t.height(t.r) + 1
+	s.MinDataFill, s.MinIndexFill = 1, 1
+	dsz, desz := unsafe.Sizeof(d[K, V]{}), unsafe.Sizeof(de[K, V]{})
+	xsz, xesz := unsafe.Sizeof(x[K, V]{}), unsafe.Sizeof(xe[K]{})
+	dmax, xmax := float64(2*t.kd), float64(2*t.kx+1)
+	var walk func(q interface{})
+	walk = func(q interface{}) {
+		switch x := q.(type) {
+		case *x[K, V]:
+			s.IndexPages++
+			s.Bytes += int64(xsz + uintptr(cap(x.x))*xesz)
+			f := float64(x.c) / xmax
+			s.IndexFill += f
+			s.MinIndexFill = min(s.MinIndexFill, f)
+			for i := 0; i <= x.c; i++ {
+				walk(x.x[i].ch)
+			}
+		case *d[K, V]:
+			s.DataPages++
+			s.Bytes += int64(dsz + uintptr(cap(x.d))*desz)
+			f := float64(x.c) / dmax
+			s.DataFill += f
+			s.MinDataFill = min(s.MinDataFill, f)
+		}
+	}
+	walk(t.r)
+	s.DataFill /= float64(s.DataPages)
+	switch s.IndexPages {
+	case 0:
+		s.MinIndexFill = 0
+	default:
+		s.IndexFill /= float64(s.IndexPages)
+	}
+	return s
+}
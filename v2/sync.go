@@ -0,0 +1,302 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"iter"
+	"sync"
+)
+
+// SyncTree is a Tree safe for concurrent use by multiple goroutines. It
+// follows the rules of the "Concurrency considerations" section of the package
+// documentation: the mutating methods hold the write lock of a sync.RWMutex,
+// the reading methods hold its read lock.
+//
+// The enumerators and iterators of a SyncTree hold the read lock only for the
+// duration of every single step, so the tree can be mutated in between the
+// steps, including from the body of a range loop. The enumeration then
+// resumes at the proper key the same way as a Tree enumeration does. A
+// SyncEnumerator itself is not safe for concurrent use.
+type SyncTree[K comparable, V interface{}] struct {
+	mu sync.RWMutex
+	t  *Tree[K, V]
+}
+
+// SyncTreeNew returns a newly created, empty SyncTree. The compare function
+// is used for key collation.
+func SyncTreeNew[K comparable, V interface{}](cmp Cmp[K]) *SyncTree[K, V] {
+	return &SyncTree[K, V]{t: TreeNew[K, V](cmp)}
+}
+
+// All returns an iterator over the KV pairs of the tree in the key collating
+// order. See Tree.All.
+func (t *SyncTree[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		e, err := t.SeekFirst()
+		if err != nil {
+			return
+		}
+
+		defer e.Close()
+		for n := 0; ; n++ {
+			k, v, err := e.advance(false, n != 0)
+			if err != nil || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the KV pairs of the tree in the reverse
+// key collating order. See Tree.Backward.
+func (t *SyncTree[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		e, err := t.SeekLast()
+		if err != nil {
+			return
+		}
+
+		defer e.Close()
+		for n := 0; ; n++ {
+			k, v, err := e.advance(true, n != 0)
+			if err != nil || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Ceil returns the item having the smallest key >= k and true, or
+// (zero-value, zero-value, false) if there is no such item.
+func (t *SyncTree[K, V]) Ceil(k K) (K, V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Ceil(k)
+}
+
+// Clear removes all K/V pairs from the tree.
+func (t *SyncTree[K, V]) Clear() {
+	t.mu.Lock()
+	t.t.Clear()
+	t.mu.Unlock()
+}
+
+// Delete removes the k's KV pair, if it exists, in which case Delete returns
+// true.
+func (t *SyncTree[K, V]) Delete(k K) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.t.Delete(k)
+}
+
+// DeleteRange removes all KV pairs having lo <= key < hi and returns their
+// number.
+func (t *SyncTree[K, V]) DeleteRange(lo, hi K) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.t.DeleteRange(lo, hi)
+}
+
+// First returns the first item of the tree in the key collating order, or
+// (zero-value, zero-value) if the tree is empty.
+func (t *SyncTree[K, V]) First() (k K, v V) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.First()
+}
+
+// Floor returns the item having the largest key <= k and true, or
+// (zero-value, zero-value, false) if there is no such item.
+func (t *SyncTree[K, V]) Floor(k K) (K, V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Floor(k)
+}
+
+// Get returns the value associated with k and true if it exists. Otherwise Get
+// returns (zero-value, false).
+func (t *SyncTree[K, V]) Get(k K) (v V, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Get(k)
+}
+
+// Higher returns the item having the smallest key > k and true, or
+// (zero-value, zero-value, false) if there is no such item.
+func (t *SyncTree[K, V]) Higher(k K) (K, V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Higher(k)
+}
+
+// Last returns the last item of the tree in the key collating order, or
+// (zero-value, zero-value) if the tree is empty.
+func (t *SyncTree[K, V]) Last() (k K, v V) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Last()
+}
+
+// Len returns the number of items in the tree.
+func (t *SyncTree[K, V]) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Len()
+}
+
+// Lower returns the item having the largest key < k and true, or (zero-value,
+// zero-value, false) if there is no such item.
+func (t *SyncTree[K, V]) Lower(k K) (K, V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Lower(k)
+}
+
+// Put combines Get and Set in a more efficient way where the tree is walked
+// only once. See Tree.Put. upd is called with the write lock held, it must not
+// call the methods of t.
+func (t *SyncTree[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.t.Put(k, upd)
+}
+
+// Range returns an iterator over the KV pairs of the tree having lo <= key <
+// hi, in the key collating order. See Tree.Range.
+func (t *SyncTree[K, V]) Range(lo, hi K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		e, _ := t.Seek(lo, UpperBound(hi, false))
+		defer e.Close()
+		for n := 0; ; n++ {
+			k, v, err := e.advance(false, n != 0)
+			if err != nil || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Rank returns the number of keys in the tree that collate before k.
+func (t *SyncTree[K, V]) Rank(k K) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Rank(k)
+}
+
+// Seek returns a SyncEnumerator positioned on an item such that k >= item's
+// key. ok reports if k == item.key The SyncEnumerator's position is possibly
+// after the last item in the tree. See Tree.Seek for the bounds.
+func (t *SyncTree[K, V]) Seek(k K, bounds ...Bound[K]) (e *SyncEnumerator[K, V], ok bool) {
+	t.mu.RLock()
+	f, ok := t.t.Seek(k, bounds...)
+	t.mu.RUnlock()
+	return &SyncEnumerator[K, V]{e: f, t: t}, ok
+}
+
+// SeekFirst returns a SyncEnumerator positioned on the first KV pair in the
+// tree, if any. For an empty tree, err == io.EOF is returned and e will be
+// nil. See Tree.SeekFirst for the bounds.
+func (t *SyncTree[K, V]) SeekFirst(bounds ...Bound[K]) (e *SyncEnumerator[K, V], err error) {
+	t.mu.RLock()
+	f, err := t.t.SeekFirst(bounds...)
+	t.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyncEnumerator[K, V]{e: f, t: t}, nil
+}
+
+// SeekLast returns a SyncEnumerator positioned on the last KV pair in the
+// tree, if any. For an empty tree, err == io.EOF is returned and e will be
+// nil. See Tree.SeekLast for the bounds.
+func (t *SyncTree[K, V]) SeekLast(bounds ...Bound[K]) (e *SyncEnumerator[K, V], err error) {
+	t.mu.RLock()
+	f, err := t.t.SeekLast(bounds...)
+	t.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyncEnumerator[K, V]{e: f, t: t}, nil
+}
+
+// Select returns the item having the i-th key of the tree in the key collating
+// order, or (zero-value, zero-value) if i is not in [0, Len()).
+func (t *SyncTree[K, V]) Select(i int) (k K, v V) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.t.Select(i)
+}
+
+// Set sets the value associated with k.
+func (t *SyncTree[K, V]) Set(k K, v V) {
+	t.mu.Lock()
+	t.t.Set(k, v)
+	t.mu.Unlock()
+}
+
+// Snapshot returns a read-only view of the current content of the tree. See
+// Tree.Snapshot. The view can be read without any locking.
+func (t *SyncTree[K, V]) Snapshot() *Tree[K, V] {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.t.Snapshot()
+}
+
+// SyncEnumerator captures the state of enumerating a SyncTree. Its Next and
+// Prev methods hold the read lock of the tree for the duration of the call.
+type SyncEnumerator[K comparable, V interface{}] struct {
+	e *Enumerator[K, V]
+	t *SyncTree[K, V]
+}
+
+// Close recycles e. No references to e should exist or such references must
+// not be used afterwards.
+func (e *SyncEnumerator[K, V]) Close() {
+	e.e.Close()
+	*e = SyncEnumerator[K, V]{}
+}
+
+// Delete removes the item last returned by Next or Prev while holding the
+// write lock of the tree. See Enumerator.Delete.
+func (e *SyncEnumerator[K, V]) Delete() bool {
+	e.t.mu.Lock()
+	defer e.t.mu.Unlock()
+	return e.e.Delete()
+}
+
+// Next returns the currently enumerated item, if it exists and moves to the
+// next item in the key collation order. If there is no item to return, err ==
+// io.EOF is returned.
+func (e *SyncEnumerator[K, V]) Next() (k K, v V, err error) {
+	e.t.mu.RLock()
+	defer e.t.mu.RUnlock()
+	return e.e.Next()
+}
+
+// Prev returns the currently enumerated item, if it exists and moves to the
+// previous item in the key collation order. If there is no item to return, err
+// == io.EOF is returned.
+func (e *SyncEnumerator[K, V]) Prev() (k K, v V, err error) {
+	e.t.mu.RLock()
+	defer e.t.mu.RUnlock()
+	return e.e.Prev()
+}
+
+// advance is Enumerator.advance holding the read lock of the tree.
+func (e *SyncEnumerator[K, V]) advance(backward, skip bool) (k K, v V, err error) {
+	e.t.mu.RLock()
+	defer e.t.mu.RUnlock()
+	return e.e.advance(backward, skip)
+}
+
+// SetValue sets the value of the item last returned by Next or Prev while
+// holding the write lock of the tree. See Enumerator.SetValue.
+func (e *SyncEnumerator[K, V]) SetValue(v V) bool {
+	e.t.mu.Lock()
+	defer e.t.mu.Unlock()
+	return e.e.SetValue(v)
+}
@@ -0,0 +1,267 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"io"
+)
+
+// Txn collects changes to a tree and applies them all at once on Commit. Until
+// then the changes are visible only through the Txn, which reads the items of
+// the tree not changed by it. The changes are held in a separate tree, the
+// tree of the Txn is not modified before Commit.
+//
+// A Txn provides no isolation from the mutations of its tree made outside of
+// it, the reads of a Txn see them. Commit is last-writer-wins: it applies the
+// changes of the Txn over the current content of the tree, overwriting any
+// changes of the same keys made since Begin.
+//
+// Txn.{Delete,Get,Put,Seek} read the tree of the Txn, Txn.Commit mutates it.
+// They have to be synchronized with the mutations of the tree the same way as
+// the respective Tree methods.
+type Txn[K comparable, V interface{}] struct {
+	d   *Tree[K, struct{}] // pending deletions of the items of t
+	t   *Tree[K, V]
+	ver int64
+	w   *Tree[K, V] // pending writes
+}
+
+// Begin returns a new, empty transaction of t. The pending changes are held in
+// trees having the same page sizes as t.
+func (t *Tree[K, V]) Begin() *Txn[K, V] {
+	return &Txn[K, V]{d: TreeNewWithOptions[K, struct{}](t.cmp, t.options()), t: t, w: t.empty()}
+}
+
+// Commit applies all changes of x to its tree. The changes are made by Set and
+// Delete, which cannot fail, so either all of them are applied or none, if
+// Commit is not called. x is empty afterwards and can be used again.
+func (x *Txn[K, V]) Commit() {
+	for k := range x.d.All() {
+		x.t.Delete(k)
+	}
+	for k, v := range x.w.All() {
+		x.t.Set(k, v)
+	}
+	x.Rollback()
+}
+
+// Rollback discards all changes of x. x is empty afterwards and can be used
+// again.
+func (x *Txn[K, V]) Rollback() {
+	x.d.Clear()
+	x.w.Clear()
+	x.ver++
+}
+
+// Delete removes the k's KV pair, if it exists, in which case Delete returns
+// true.
+func (x *Txn[K, V]) Delete(k K) (ok bool) {
+	if _, ok = x.Get(k); !ok {
+		return false
+	}
+
+	x.ver++
+	x.w.Delete(k)
+	if _, ok := x.t.Get(k); ok {
+		x.d.Set(k, struct{}{})
+	}
+	return true
+}
+
+// Get returns the value associated with k and true if it exists. Otherwise Get
+// returns (zero-value, false).
+func (x *Txn[K, V]) Get(k K) (v V, ok bool) {
+	if v, ok = x.w.Get(k); ok {
+		return v, true
+	}
+
+	if _, ok = x.d.Get(k); ok {
+		return v, false
+	}
+
+	return x.t.Get(k)
+}
+
+// Put combines Get and Set the same way as Tree.Put does.
+func (x *Txn[K, V]) Put(k K, upd Updater[V]) (oldV V, written bool) {
+	var exists bool
+	oldV, exists = x.Get(k)
+	var newV V
+	if newV, written = upd(oldV, exists); written {
+		x.Set(k, newV)
+	}
+	return oldV, written
+}
+
+// Set sets the value associated with k.
+func (x *Txn[K, V]) Set(k K, v V) {
+	x.ver++
+	x.d.Delete(k)
+	x.w.Set(k, v)
+}
+
+// Seek returns a TxnEnumerator positioned on an item such that k >= item's
+// key. ok reports if k == item.key The TxnEnumerator's position is possibly
+// after the last item.
+func (x *Txn[K, V]) Seek(k K) (e *TxnEnumerator[K, V], ok bool) {
+	_, ok = x.Get(k)
+	return &TxnEnumerator[K, V]{k: k, x: x}, ok
+}
+
+// TxnEnumerator captures the state of enumerating the items of a Txn. It
+// merges the items of the tree of the Txn with the pending changes and
+// behaves like an Enumerator, including resuming at the proper key after
+// mutations and the "sticky" io.EOF.
+type TxnEnumerator[K comparable, V interface{}] struct {
+	a    *Enumerator[K, V] // the tree
+	b    *Enumerator[K, V] // the pending writes
+	dir  int               // direction of a and b: 1 forward, -1 backward, 0 not positioned
+	err  error
+	k    K
+	st   int // 1: k was returned by Next, -1: k was returned by Prev, 0: k was sought or the trees were mutated since
+	ver  int64
+	xver int64
+	x    *Txn[K, V]
+}
+
+// Close recycles the enumerators used by e. e must not be used afterwards.
+func (e *TxnEnumerator[K, V]) Close() {
+	e.close()
+	*e = TxnEnumerator[K, V]{}
+}
+
+func (e *TxnEnumerator[K, V]) close() {
+	if e.a != nil {
+		e.a.Close()
+		e.b.Close()
+		e.a, e.b = nil, nil
+	}
+}
+
+// position seeks the enumerators of both trees to k in direction dir. The
+// item at k is skipped if it was already returned.
+func (e *TxnEnumerator[K, V]) position(dir int) {
+	e.close()
+	e.a = e.x.t.seekDir(e.k, dir, e.st != 0)
+	e.b = e.x.w.seekDir(e.k, dir, e.st != 0)
+	e.dir, e.ver, e.xver = dir, e.x.t.ver, e.x.ver
+}
+
+// seekDir returns an enumerator positioned on the first item at or after k in
+// direction dir, or strictly after k if excl is true. The enumerator must be
+// moved only by step.
+func (t *Tree[K, V]) seekDir(k K, dir int, excl bool) *Enumerator[K, V] {
+	e, hit := t.Seek(k)
+	switch {
+	case dir > 0:
+		if e.q != nil && e.i >= e.q.c {
+			e.next()
+		}
+	default:
+		if !hit {
+			e.prev()
+		}
+		if e.q != nil && e.i >= e.q.c {
+			e.prev()
+		}
+	}
+	if hit && excl {
+		e.step(dir)
+	}
+	return e
+}
+
+// item returns the item e is positioned on, if any.
+func (e *Enumerator[K, V]) item() *de[K, V] {
+	if e.err != nil || e.q == nil {
+		return nil
+	}
+
+	return &e.q.d[e.i]
+}
+
+func (e *Enumerator[K, V]) step(dir int) {
+	switch {
+	case dir > 0:
+		e.next()
+	default:
+		e.prev()
+	}
+}
+
+// peek returns the current item. It reports whether the item comes from the
+// tree.
+func (e *TxnEnumerator[K, V]) peek() (p *de[K, V], tree bool) {
+	b := e.b.item()
+	for {
+		a := e.a.item()
+		if a == nil {
+			return b, false
+		}
+
+		if _, ok := e.x.d.Get(a.k); ok {
+			e.a.step(e.dir) // Deleted.
+			continue
+		}
+
+		if b == nil {
+			return a, true
+		}
+
+		switch c := e.x.t.cmp(a.k, b.k) * e.dir; {
+		case c < 0:
+			return a, true
+		case c == 0:
+			e.a.step(e.dir) // Overwritten.
+			continue
+		}
+
+		return b, false
+	}
+}
+
+// move returns the current item and moves to the next item in direction dir.
+func (e *TxnEnumerator[K, V]) move(dir int) (k K, v V, err error) {
+	if err = e.err; err != nil {
+		return
+	}
+
+	if e.dir == 0 || e.ver != e.x.t.ver || e.xver != e.x.ver {
+		// Resume at k, it is returned again if it still exists, the
+		// same way as by an Enumerator.
+		e.st = 0
+		e.position(dir)
+	}
+	p, tree := e.peek()
+	if p == nil {
+		e.err, err = io.EOF, io.EOF
+		return
+	}
+
+	k, v = p.k, p.v
+	e.k, e.st = k, dir
+	switch {
+	case e.dir != dir:
+		e.position(dir)
+	case tree:
+		e.a.step(dir)
+	default:
+		e.b.step(dir)
+	}
+	if p, _ := e.peek(); p == nil {
+		e.err = io.EOF // Same as Enumerator.{next,prev}.
+	}
+	return k, v, nil
+}
+
+// Next returns the currently enumerated item, if it exists and moves to the
+// next item in the key collation order. If there is no item to return, err ==
+// io.EOF is returned.
+func (e *TxnEnumerator[K, V]) Next() (k K, v V, err error) { return e.move(1) }
+
+// Prev returns the currently enumerated item, if it exists and moves to the
+// previous item in the key collation order. If there is no item to return, err
+// == io.EOF is returned.
+func (e *TxnEnumerator[K, V]) Prev() (k K, v V, err error) { return e.move(-1) }
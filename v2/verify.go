@@ -0,0 +1,183 @@
+// Copyright 2021 The B Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b/v2"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// verifier holds the state of Tree.Verify.
+type verifier[K comparable, V interface{}] struct {
+	depth int // of the data pages, -1 until the first one is seen
+	first *d[K, V]
+	path  []int
+	prev  *d[K, V] // last data page seen
+	seen  map[interface{}]struct{}
+	t     *Tree[K, V]
+}
+
+// Verify checks the structural integrity of t. It returns an error describing
+// the first violation found, if any. The error names the path of the
+// offending page, a list of child indices starting at the root, and the
+// offending key, if any.
+//
+// Verify checks that the keys are in strictly increasing order within and
+// across pages, that every key of an index page separates the keys of its
+// children, that all pages but the root are filled within the bounds given
+// by the page sizes of the tree, that all data pages are at the same depth,
+// that the data pages are correctly linked in both directions and from the
+// first and last fields of the tree and that the number of items and the
+// subtree counts of the index pages match the real number of items.
+//
+// The data page links of a snapshot belong to the tree it was taken from, so
+// they are not checked in snapshots.
+//
+// Verify reads the whole tree, it is intended for debugging, eg. when a
+// compare function is suspected of being inconsistent.
+func (t *Tree[K, V]) Verify() error {
+	if t.r == nil {
+		switch {
+		case t.c != 0:
+			return fmt.Errorf("Verify: empty tree has Len %d", t.c)
+		case t.first != nil || t.last != nil:
+			return fmt.Errorf("Verify: empty tree has data pages linked")
+		}
+		return nil
+	}
+
+	v := &verifier[K, V]{depth: -1, seen: map[interface{}]struct{}{}, t: t}
+	n, err := v.page(t.r, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case t.first != v.first || !t.ro && t.first.p != nil:
+		return fmt.Errorf("Verify: first is not the first data page")
+	case t.last != v.prev || !t.ro && t.last.n != nil:
+		return fmt.Errorf("Verify: last is not the last data page")
+	case n != t.c:
+		return fmt.Errorf("Verify: tree has %d items, Len is %d", n, t.c)
+	}
+	return nil
+}
+
+// errorf returns an error prefixed by the path of the current page.
+func (v *verifier[K, V]) errorf(format string, args ...interface{}) error {
+	var b strings.Builder
+	b.WriteString("root")
+	for _, i := range v.path {
+		fmt.Fprintf(&b, "/%d", i)
+	}
+	return fmt.Errorf("Verify: page %s: %s", b.String(), fmt.Sprintf(format, args...))
+}
+
+// key checks that the key k at index i of the current page is in the range
+// [lo, hi) and follows prev.
+func (v *verifier[K, V]) key(k K, i int, prev, lo, hi *K) error {
+	t := v.t
+	switch {
+	case prev != nil && t.cmp(*prev, k) >= 0:
+		return v.errorf("key #%d %v: not greater than the previous key %v", i, k, *prev)
+	case lo != nil && t.cmp(k, *lo) < 0:
+		return v.errorf("key #%d %v: less than the separator %v in the parent page", i, k, *lo)
+	case hi != nil && t.cmp(k, *hi) >= 0:
+		return v.errorf("key #%d %v: not less than the separator %v in the parent page", i, k, *hi)
+	}
+	return nil
+}
+
+// page checks the subtree q having keys in [lo, hi) and returns the number of
+// its items.
+func (v *verifier[K, V]) page(q interface{}, lo, hi *K) (n int, err error) {
+	t := v.t
+	root := len(v.path) == 0
+	if _, ok := v.seen[q]; ok {
+		return 0, v.errorf("page reachable more than once")
+	}
+
+	v.seen[q] = struct{}{}
+	switch x := q.(type) {
+	case *x[K, V]:
+		switch {
+		case x.c > 2*t.kx+1:
+			return 0, v.errorf("index page has %d keys, maximum is %d", x.c, 2*t.kx+1)
+		case root && x.c < 1:
+			return 0, v.errorf("root index page has no keys")
+		case !root && x.c < t.kx-1:
+			return 0, v.errorf("index page has %d keys, minimum is %d", x.c, t.kx-1)
+		}
+
+		for i := 0; i < x.c; i++ {
+			var prev *K
+			if i > 0 {
+				prev = &x.x[i-1].k
+			}
+			if err := v.key(x.x[i].k, i, prev, lo, hi); err != nil {
+				return 0, err
+			}
+		}
+		for i := 0; i <= x.c; i++ {
+			l, h := lo, hi
+			if i > 0 {
+				l = &x.x[i-1].k
+			}
+			if i < x.c {
+				h = &x.x[i].k
+			}
+			v.path = append(v.path, i)
+			m, err := v.page(x.x[i].ch, l, h)
+			if err != nil {
+				return 0, err
+			}
+
+			v.path = v.path[:len(v.path)-1]
+			n += m
+		}
+		if n != x.n {
+			return 0, v.errorf("index page subtree has %d items, its count is %d", n, x.n)
+		}
+
+		return n, nil
+	case *d[K, V]:
+		switch {
+		case x.c > 2*t.kd:
+			return 0, v.errorf("data page has %d items, maximum is %d", x.c, 2*t.kd)
+		case root && x.c < 1:
+			return 0, v.errorf("root data page has no items")
+		case !root && x.c < t.kd:
+			return 0, v.errorf("data page has %d items, minimum is %d", x.c, t.kd)
+		case v.depth >= 0 && len(v.path) != v.depth:
+			return 0, v.errorf("data page at depth %d, expected %d", len(v.path), v.depth)
+		case !t.ro && x.p != v.prev:
+			return 0, v.errorf("data page is not linked to the previous data page")
+		case !t.ro && v.prev != nil && v.prev.n != x:
+			return 0, v.errorf("previous data page is not linked to the data page")
+		}
+
+		var prev *K
+		if v.prev != nil {
+			prev = &v.prev.d[v.prev.c-1].k
+		} else {
+			v.first = x
+		}
+		v.depth = len(v.path)
+		v.prev = x
+		for i := 0; i < x.c; i++ {
+			if i > 0 {
+				prev = &x.d[i-1].k
+			}
+			if err := v.key(x.d[i].k, i, prev, lo, hi); err != nil {
+				return 0, err
+			}
+		}
+		return x.c, nil
+	case nil:
+		return 0, v.errorf("nil page")
+	default:
+		return 0, v.errorf("invalid page type %T", q)
+	}
+}
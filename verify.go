@@ -0,0 +1,175 @@
+// Copyright 2014 The b Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b // import "modernc.org/b"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// verifier holds the state of Tree.Verify.
+type verifier struct {
+	depth int // of the data pages, -1 until the first one is seen
+	first *d
+	path  []int
+	prev  *d // last data page seen
+	seen  map[interface{}]struct{}
+	t     *Tree
+}
+
+// Verify checks the structural integrity of t. It returns an error describing
+// the first violation found, if any. The error names the path of the
+// offending page, a list of child indices starting at the root, and the
+// offending key, if any.
+//
+// Verify checks that the keys are in strictly increasing order within and
+// across pages, that every key of an index page separates the keys of its
+// children, that all pages but the root are filled within the bounds given
+// by kd and kx, that all data pages are at the same depth, that the data
+// pages are correctly linked in both directions and from the first and last
+// fields of the tree and that the number of items matches the real number of
+// items.
+//
+// Verify reads the whole tree, it is intended for debugging, eg. when a
+// compare function is suspected of being inconsistent.
+func (t *Tree) Verify() error {
+	if t.r == nil {
+		switch {
+		case t.c != 0:
+			return fmt.Errorf("Verify: empty tree has Len %d", t.c)
+		case t.first != nil || t.last != nil:
+			return fmt.Errorf("Verify: empty tree has data pages linked")
+		}
+		return nil
+	}
+
+	v := &verifier{depth: -1, seen: map[interface{}]struct{}{}, t: t}
+	n, err := v.page(t.r, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case t.first != v.first || t.first.p != nil:
+		return fmt.Errorf("Verify: first is not the first data page")
+	case t.last != v.prev || t.last.n != nil:
+		return fmt.Errorf("Verify: last is not the last data page")
+	case n != t.c:
+		return fmt.Errorf("Verify: tree has %d items, Len is %d", n, t.c)
+	}
+	return nil
+}
+
+// errorf returns an error prefixed by the path of the current page.
+func (v *verifier) errorf(format string, args ...interface{}) error {
+	var b strings.Builder
+	b.WriteString("root")
+	for _, i := range v.path {
+		fmt.Fprintf(&b, "/%d", i)
+	}
+	return fmt.Errorf("Verify: page %s: %s", b.String(), fmt.Sprintf(format, args...))
+}
+
+// key checks that the key k at index i of the current page is in the range
+// [lo, hi) and follows prev.
+func (v *verifier) key(k interface{} /*K*/, i int, prev, lo, hi *interface{} /*K*/) error {
+	t := v.t
+	switch {
+	case prev != nil && t.cmp(*prev, k) >= 0:
+		return v.errorf("key #%d %v: not greater than the previous key %v", i, k, *prev)
+	case lo != nil && t.cmp(k, *lo) < 0:
+		return v.errorf("key #%d %v: less than the separator %v in the parent page", i, k, *lo)
+	case hi != nil && t.cmp(k, *hi) >= 0:
+		return v.errorf("key #%d %v: not less than the separator %v in the parent page", i, k, *hi)
+	}
+	return nil
+}
+
+// page checks the subtree q having keys in [lo, hi) and returns the number of
+// its items.
+func (v *verifier) page(q interface{}, lo, hi *interface{} /*K*/) (n int, err error) {
+	root := len(v.path) == 0
+	if _, ok := v.seen[q]; ok {
+		return 0, v.errorf("page reachable more than once")
+	}
+
+	v.seen[q] = struct{}{}
+	switch x := q.(type) {
+	case *x:
+		switch {
+		case x.c > 2*kx+1:
+			return 0, v.errorf("index page has %d keys, maximum is %d", x.c, 2*kx+1)
+		case root && x.c < 1:
+			return 0, v.errorf("root index page has no keys")
+		case !root && x.c < kx-1:
+			return 0, v.errorf("index page has %d keys, minimum is %d", x.c, kx-1)
+		}
+
+		for i := 0; i < x.c; i++ {
+			var prev *interface{} /*K*/
+			if i > 0 {
+				prev = &x.x[i-1].k
+			}
+			if err := v.key(x.x[i].k, i, prev, lo, hi); err != nil {
+				return 0, err
+			}
+		}
+		for i := 0; i <= x.c; i++ {
+			l, h := lo, hi
+			if i > 0 {
+				l = &x.x[i-1].k
+			}
+			if i < x.c {
+				h = &x.x[i].k
+			}
+			v.path = append(v.path, i)
+			m, err := v.page(x.x[i].ch, l, h)
+			if err != nil {
+				return 0, err
+			}
+
+			v.path = v.path[:len(v.path)-1]
+			n += m
+		}
+		return n, nil
+	case *d:
+		switch {
+		case x.c > 2*kd:
+			return 0, v.errorf("data page has %d items, maximum is %d", x.c, 2*kd)
+		case root && x.c < 1:
+			return 0, v.errorf("root data page has no items")
+		case !root && x.c < kd:
+			return 0, v.errorf("data page has %d items, minimum is %d", x.c, kd)
+		case v.depth >= 0 && len(v.path) != v.depth:
+			return 0, v.errorf("data page at depth %d, expected %d", len(v.path), v.depth)
+		case x.p != v.prev:
+			return 0, v.errorf("data page is not linked to the previous data page")
+		case v.prev != nil && v.prev.n != x:
+			return 0, v.errorf("previous data page is not linked to the data page")
+		}
+
+		var prev *interface{} /*K*/
+		if v.prev != nil {
+			prev = &v.prev.d[v.prev.c-1].k
+		} else {
+			v.first = x
+		}
+		v.depth = len(v.path)
+		v.prev = x
+		for i := 0; i < x.c; i++ {
+			if i > 0 {
+				prev = &x.d[i-1].k
+			}
+			if err := v.key(x.d[i].k, i, prev, lo, hi); err != nil {
+				return 0, err
+			}
+		}
+		return x.c, nil
+	case nil:
+		return 0, v.errorf("nil page")
+	default:
+		return 0, v.errorf("invalid page type %T", q)
+	}
+}